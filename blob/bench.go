@@ -0,0 +1,115 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+)
+
+func benchCmd(env *command.Env, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage is: bench")
+	}
+	cfg := env.Config.(*settings)
+	if !cfg.Confirm {
+		return errors.New("the benchmark writes to the store; set -confirm to proceed")
+	} else if cfg.Count <= 0 || cfg.Size <= 0 {
+		return errors.New("the -n and -size values must be positive")
+	}
+	bs, err := storeFromEnv(env)
+	if err != nil {
+		return err
+	}
+	ctx := getContext(env)
+	defer blob.CloseStore(ctx, bs)
+
+	// Generate random keys and data, so that the benchmark neither collides
+	// with existing data nor benefits from compression.
+	var tag [8]byte
+	if _, err := rand.Read(tag[:]); err != nil {
+		return err
+	}
+	keys := make([]string, cfg.Count)
+	for i := range keys {
+		keys[i] = "bench-" + hex.EncodeToString(tag[:]) + "-" + strconv.Itoa(i)
+	}
+	data := make([]byte, cfg.Size)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+
+	// Clean up benchmark data even if a step fails.
+	var written []string
+	defer func() {
+		for _, key := range written {
+			bs.Delete(ctx, key)
+		}
+	}()
+
+	fmt.Printf("Benchmark: %d blobs of %d bytes\n", cfg.Count, cfg.Size)
+	if err := benchOp(ctx, "put", keys, func(ctx context.Context, key string) error {
+		err := bs.Put(ctx, blob.PutOptions{Key: key, Data: data})
+		if err == nil {
+			written = append(written, key)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := benchOp(ctx, "get", keys, func(ctx context.Context, key string) error {
+		_, err := bs.Get(ctx, key)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := benchOp(ctx, "delete", keys, func(ctx context.Context, key string) error {
+		return bs.Delete(ctx, key)
+	}); err != nil {
+		return err
+	}
+	written = nil
+	return nil
+}
+
+// benchOp calls op for each key in sequence, and prints a summary of the
+// throughput and latency distribution of the calls.
+func benchOp(ctx context.Context, name string, keys []string, op func(context.Context, string) error) error {
+	lat := make([]time.Duration, len(keys))
+	start := time.Now()
+	for i, key := range keys {
+		st := time.Now()
+		if err := op(ctx, key); err != nil {
+			return fmt.Errorf("%s %q: %w", name, key, err)
+		}
+		lat[i] = time.Since(st)
+	}
+	elapsed := time.Since(start)
+
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	pct := func(p int) time.Duration { return lat[(len(lat)-1)*p/100] }
+	fmt.Printf("%-6s %10.1f ops/sec  p50=%v p90=%v p99=%v max=%v\n", name,
+		float64(len(keys))/elapsed.Seconds(), pct(50), pct(90), pct(99), lat[len(lat)-1])
+	return nil
+}
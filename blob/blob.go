@@ -32,17 +32,33 @@ type settings struct {
 	FFS     *config.Settings
 
 	// Flag targets
-	Store     string // global
-	Bucket    string // global
-	Mode      string // global
-	Debug     bool   // global
-	Replace   bool   // put
-	Raw       bool   // list
-	Start     string // list
-	Prefix    string // list
-	MissingOK bool   // delete
+	Store     string  // global
+	Bucket    string  // global
+	Mode      string  // global
+	Debug     bool    // global
+	Replace   bool    // put
+	Raw       bool    // list, sample
+	Start     string  // list, fsck
+	Prefix    string  // list, sample, fsck, delete
+	MissingOK bool    // delete, refresh
+	All       bool    // delete
+	Yes       bool    // delete
+	Every     bool    // delete
+	Count     int     // bench, sample
+	Size      int     // bench
+	Confirm   bool    // bench
+	Workers   int     // fsck, refresh, delete
+	Rate      float64 // refresh
+	KeysFrom  string  // refresh
+	Sample    float64 // fsck
+	Content   bool    // sample
+	JSON      bool    // config
+	Output    string  // get
 }
 
+// validModes are the accepted values of the -mode flag.
+var validModes = map[string]bool{"jrpc": true, "jrpc2": true, "chirp": true}
+
 func main() {
 	command.RunOrFail(tool.NewEnv(&settings{
 		Context: context.Background(),
@@ -73,16 +89,19 @@ the address or an @tag from the configuration file.
 		cfg := env.Config.(*settings)
 		fs.StringVar(&cfg.Store, "store", "", "Blob store address (required)")
 		fs.StringVar(&cfg.Bucket, "bucket", "", "Prefix to add to all keys")
-		fs.StringVar(&cfg.Mode, "mode", "jrpc2", "Service mode (jrpc2 or chirp")
+		fs.StringVar(&cfg.Mode, "mode", "jrpc2", "Service mode (jrpc2 or chirp)")
 		fs.BoolVar(&cfg.Debug, "debug", false, "Enable client debug logging")
 	},
 
 	Init: func(env *command.Env) error {
+		cfg := env.Config.(*settings)
+		if !validModes[cfg.Mode] {
+			return fmt.Errorf("unknown service -mode %q (want jrpc2 or chirp)", cfg.Mode)
+		}
 		fc, err := config.Load(config.Path())
 		if err != nil {
 			return fmt.Errorf("loading FFS config: %w", err)
 		}
-		cfg := env.Config.(*settings)
 		if cfg.Store != "" {
 			fc.DefaultStore = cfg.Store
 		} else if bs := os.Getenv("BLOB_STORE"); bs != "" {
@@ -97,8 +116,20 @@ the address or an @tag from the configuration file.
 		{
 			Name:  "get",
 			Usage: "get <key>...",
-			Help:  "Read blobs from the store",
-			Run:   getCmd,
+			Help: `Read blobs from the store
+
+By default, the contents of the blobs are concatenated to stdout.
+
+With -o, if the named path is an existing directory, each blob is written
+to a file in that directory named by its key in hex. Otherwise exactly one
+key is allowed, and its blob is written to the named file. Each output
+file is replaced atomically, only if its read succeeds.`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				cfg := env.Config.(*settings)
+				fs.StringVar(&cfg.Output, "o", "-", `Write output to this file ("-" for stdout)`)
+			},
+			Run: getCmd,
 		},
 		{
 			Name:  "put",
@@ -118,13 +149,25 @@ the address or an @tag from the configuration file.
 			Run:   sizeCmd,
 		},
 		{
-			Name:  "delete",
-			Usage: "delete <key>",
-			Help:  "Delete a blob from the store",
+			Name: "delete",
+			Usage: `delete <key>...
+delete -all -prefix <prefix> -yes`,
+			Help: `Delete blobs from the store
+
+With -all, no keys are given; instead every key having the given -prefix
+is deleted, concurrently. Since this is destructive, -yes is required.
+An empty prefix would delete the entire store, so it is refused unless
+-everything is also set. Each deleted key is printed, followed by the
+number of keys deleted.`,
 
 			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
 				cfg := env.Config.(*settings)
 				fs.BoolVar(&cfg.MissingOK, "missing-ok", false, "Do not report an error for missing keys")
+				fs.BoolVar(&cfg.All, "all", false, "Delete all keys having the -prefix")
+				fs.StringVar(&cfg.Prefix, "prefix", "", "With -all, the prefix of keys to delete")
+				fs.BoolVar(&cfg.Yes, "yes", false, "With -all, confirm that keys may be deleted")
+				fs.BoolVar(&cfg.Every, "everything", false, "With -all, allow an empty -prefix")
+				fs.IntVar(&cfg.Workers, "concurrency", 16, "With -all, the number of concurrent deletes")
 			},
 			Run: delCmd,
 		},
@@ -172,11 +215,119 @@ the address or an @tag from the configuration file.
 			},
 			Run: copyCmd,
 		},
+		{
+			Name:  "refresh",
+			Usage: "refresh <key>...",
+			Help: `Rewrite blobs in place to refresh their storage lifetime
+
+Each blob is read and written back to the same key, replacing itself.
+This resets the expiration or last-access time of blobs in backends
+that expire data that is not written, such as caches and object stores
+with lifecycle rules.
+
+Keys may be given as arguments, or listed one per line in the file named
+by -keys ("-" for stdin), for example from the output of "blob list".
+Use -rate to limit the number of blobs refreshed per second.`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				cfg := env.Config.(*settings)
+				fs.StringVar(&cfg.KeysFrom, "keys", "", `Read keys from this file ("-" for stdin)`)
+				fs.IntVar(&cfg.Workers, "concurrency", 16, "Number of concurrent refreshes")
+				fs.Float64Var(&cfg.Rate, "rate", 0, "Maximum blobs per second (0 means no limit)")
+				fs.BoolVar(&cfg.MissingOK, "missing-ok", false, "Do not report an error for missing keys")
+			},
+			Run: refreshCmd,
+		},
 		{
 			Name: "status",
 			Help: "Print blob server status",
 			Run:  statCmd,
 		},
+		{
+			Name: "config",
+			Help: `Print the effective settings of the blob server
+
+Report how the server was configured, including its store address,
+whether it uses compression, encryption, a cache, or a write-behind
+buffer, and its build version. This requires the jrpc2 service mode.`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				cfg := env.Config.(*settings)
+				fs.BoolVar(&cfg.JSON, "json", false, "Print the settings as JSON")
+			},
+			Run: configCmd,
+		},
+		{
+			Name: "bench",
+			Help: `Measure store throughput and latency
+
+Write -n blobs of -size bytes each to the store, read them back, and then
+delete them, reporting the throughput and latency distribution for each
+operation. Since this writes to the store, -confirm must be set.`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				cfg := env.Config.(*settings)
+				fs.IntVar(&cfg.Count, "n", 100, "Number of blobs to write")
+				fs.IntVar(&cfg.Size, "size", 4096, "Size of each blob in bytes")
+				fs.BoolVar(&cfg.Confirm, "confirm", false, "Confirm that benchmark data may be written")
+			},
+			Run: benchCmd,
+		},
+		{
+			Name: "fsck",
+			Help: `Check the integrity of content-addressed blobs
+
+List every key in the store, fetch each content-addressed blob, and check
+that the hash of its content matches its key. Keys whose length does not
+match the length of a content address are skipped. Mismatched keys are
+printed to stdout, and the command fails if any are found.
+
+For an FFS store, use -bucket " " to select the content-addressed keys.
+Use -prefix and -start to check only a range of keys, as for list, and
+-sample to check only a random fraction of the keys. A final PASS or
+FAIL verdict is printed with the number of mismatches.
+
+While checking, a progress bar is drawn if stderr is a terminal. Send
+SIGUSR1 to print the progress to stderr.`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				cfg := env.Config.(*settings)
+				fs.StringVar(&cfg.Start, "start", "", "Check keys greater than or equal to this")
+				fs.StringVar(&cfg.Prefix, "prefix", "", "Check only keys having this prefix")
+				fs.IntVar(&cfg.Workers, "concurrency", 16, "Number of concurrent checks")
+				fs.Float64Var(&cfg.Sample, "sample", 1, "Fraction of keys to check (0 < s <= 1)")
+			},
+			Run: fsckCmd,
+		},
+		{
+			Name: "buckets",
+			Help: `List the key prefixes in use in the store
+
+The store has no record of which buckets exist, so this lists every key
+in the store (ignoring -bucket), and groups them by their first byte,
+which is how FFS uses buckets. The number of keys with each prefix is
+printed, and the buckets known to be used by FFS are labelled. A bucket
+prefix longer than one byte is reported by its first byte.`,
+			Run: bucketsCmd,
+		},
+		{
+			Name: "sample",
+			Help: `Print a random sample of keys from the store
+
+List the keys in the store having the given -prefix, and choose -n of them
+uniformly at random. The chosen keys are printed with their sizes, in key
+order. With -content, a hex dump of each blob is also printed. Memory use
+is bounded by the sample size, regardless of the size of the store.`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				cfg := env.Config.(*settings)
+				fs.IntVar(&cfg.Count, "n", 10, "Number of keys to sample")
+				fs.StringVar(&cfg.Prefix, "prefix", "", "Sample only keys having this prefix")
+				fs.BoolVar(&cfg.Raw, "raw", false, "Print raw keys without hex encoding")
+				fs.BoolVar(&cfg.Content, "content", false, "Print the content of each sampled blob")
+			},
+			Run: sampleCmd,
+		},
 		command.HelpCommand(nil),
 	},
 }
@@ -0,0 +1,109 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/chirp"
+	cchannel "github.com/creachadair/chirp/channel"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffstools/ffs/config"
+	jchannel "github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/server"
+	"github.com/creachadair/rpcstore"
+)
+
+// startServer starts a store service in the given mode on a Unix socket in a
+// temporary directory, and returns its address.
+func startServer(t *testing.T, ctx context.Context, mode string) string {
+	t.Helper()
+	addr := filepath.Join(t.TempDir(), "sock")
+	lst, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { lst.Close() })
+
+	store := blob.NewCAS(memstore.New(), sha256.New)
+	switch mode {
+	case "jrpc2":
+		svc := rpcstore.NewService(store, nil).Methods()
+		go server.Loop(ctx, server.NetAccepter(lst, jchannel.Line), server.Static(svc), nil)
+	case "chirp":
+		svc := chirpstore.NewService(store, nil)
+		go func() {
+			for {
+				conn, err := lst.Accept()
+				if err != nil {
+					return
+				}
+				p := chirp.NewPeer()
+				svc.Register(p)
+				p.Start(cchannel.IO(conn, conn))
+			}
+		}()
+	default:
+		t.Fatalf("Unknown mode %q", mode)
+	}
+	return addr
+}
+
+func TestModes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, mode := range []string{"jrpc2", "chirp"} {
+		t.Run(mode, func(t *testing.T) {
+			addr := startServer(t, ctx, mode)
+			env := tool.NewEnv(&settings{
+				Context: ctx,
+				FFS:     &config.Settings{Context: ctx, DefaultStore: addr},
+				Mode:    mode,
+			})
+			bs, err := storeFromEnv(env)
+			if err != nil {
+				t.Fatalf("storeFromEnv: %v", err)
+			}
+			defer blob.CloseStore(ctx, bs)
+
+			const data = "hello, world"
+			key, err := bs.CASPut(ctx, []byte(data))
+			if err != nil {
+				t.Fatalf("CASPut: %v", err)
+			}
+			got, err := bs.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get %x: %v", key, err)
+			} else if string(got) != data {
+				t.Errorf("Get %x: got %q, want %q", key, got, data)
+			}
+		})
+	}
+}
+
+func TestUnknownMode(t *testing.T) {
+	env := tool.NewEnv(&settings{Context: context.Background(), Mode: "bogus"})
+	if err := tool.Init(env); err == nil || !strings.Contains(err.Error(), "unknown service -mode") {
+		t.Errorf("Init with -mode bogus: got %v, want unknown mode error", err)
+	}
+}
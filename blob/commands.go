@@ -24,19 +24,25 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"text/tabwriter"
 
+	"github.com/creachadair/atomicfile"
 	"github.com/creachadair/chirp"
 	cchannel "github.com/creachadair/chirp/channel"
 	"github.com/creachadair/chirpstore"
 	"github.com/creachadair/command"
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/storage/prefixed"
+	"github.com/creachadair/ffstools/ffs/config"
 	"github.com/creachadair/jrpc2"
 	jchannel "github.com/creachadair/jrpc2/channel"
 	"github.com/creachadair/rpcstore"
+	"github.com/creachadair/taskgroup"
 )
 
 func getContext(env *command.Env) context.Context {
@@ -55,16 +61,49 @@ func getCmd(env *command.Env, args []string) error {
 	nctx := getContext(env)
 	defer blob.CloseStore(nctx, bs)
 
+	out := env.Config.(*settings).Output
+	if out != "" && out != "-" {
+		if fi, err := os.Stat(out); err == nil && fi.IsDir() {
+			return getToDir(nctx, bs, out, args)
+		} else if len(args) != 1 {
+			return fmt.Errorf("-o %q is not a directory, so exactly one key is allowed", out)
+		}
+	}
+	return config.WithOutput(out, func(w io.Writer) error {
+		for _, arg := range args {
+			key, err := parseKey(arg)
+			if err != nil {
+				return err
+			}
+			data, err := bs.Get(nctx, key)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getToDir writes the blob for each key in args to a file in dir named by the
+// key in hex.
+func getToDir(ctx context.Context, bs blob.CAS, dir string, args []string) error {
 	for _, arg := range args {
 		key, err := parseKey(arg)
 		if err != nil {
 			return err
 		}
-		data, err := bs.Get(nctx, key)
+		data, err := bs.Get(ctx, key)
 		if err != nil {
 			return err
 		}
-		os.Stdout.Write(data)
+		path := filepath.Join(dir, hex.EncodeToString([]byte(key)))
+		if err := atomicfile.WriteData(path, data, 0644); err != nil {
+			return err
+		}
+		fmt.Println(path)
 	}
 	return nil
 }
@@ -96,6 +135,9 @@ func sizeCmd(env *command.Env, args []string) error {
 }
 
 func delCmd(env *command.Env, args []string) (err error) {
+	if env.Config.(*settings).All {
+		return delAllCmd(env, args)
+	}
 	if len(args) == 0 {
 		//lint:ignore ST1005 The punctuation signifies repetition to the user.
 		return errors.New("usage is: delete <key>...")
@@ -123,6 +165,67 @@ func delCmd(env *command.Env, args []string) (err error) {
 	return nil
 }
 
+func delAllCmd(env *command.Env, args []string) error {
+	cfg := env.Config.(*settings)
+	if len(args) != 0 {
+		return errors.New("usage is: delete -all -prefix <prefix> -yes")
+	} else if !cfg.Yes {
+		return errors.New("delete -all removes every matching key; set -yes to confirm")
+	} else if cfg.Workers <= 0 {
+		return errors.New("the -concurrency value must be positive")
+	}
+	pfx, err := parseKey(cfg.Prefix)
+	if err != nil {
+		return err
+	} else if pfx == "" && !cfg.Every {
+		return errors.New("an empty -prefix deletes the whole store; set -everything to confirm")
+	}
+	bs, err := storeFromEnv(env)
+	if err != nil {
+		return err
+	}
+	ctx := getContext(env)
+	defer blob.CloseStore(ctx, bs)
+
+	// Collect the keys before deleting, so that deletions do not disturb the
+	// listing.
+	var keys []string
+	if err := bs.List(ctx, pfx, func(key string) error {
+		if !strings.HasPrefix(key, pfx) {
+			if key > pfx {
+				return blob.ErrStopListing
+			}
+			return nil
+		}
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var numDeleted int
+	g, run := taskgroup.New(nil).Limit(cfg.Workers)
+	for _, key := range keys {
+		key := key
+		run(func() error {
+			if err := bs.Delete(ctx, key); blob.IsKeyNotFound(err) && cfg.MissingOK {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("delete %x: %w", key, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			numDeleted++
+			fmt.Println(hex.EncodeToString([]byte(key)))
+			return nil
+		})
+	}
+	err = g.Wait()
+	fmt.Fprintf(env, "Deleted %d of %d keys\n", numDeleted, len(keys))
+	return err
+}
+
 func listCmd(env *command.Env, args []string) error {
 	if len(args) != 0 {
 		return errors.New("usage is: list")
@@ -161,6 +264,52 @@ func listCmd(env *command.Env, args []string) error {
 	})
 }
 
+// knownBuckets describes the bucket prefixes used by FFS.
+var knownBuckets = map[string]string{
+	" ": "ffs data",
+	"@": "ffs roots",
+}
+
+func bucketsCmd(env *command.Env, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage is: buckets")
+	}
+	env.Config.(*settings).Bucket = ""
+	bs, err := storeFromEnv(env)
+	if err != nil {
+		return err
+	}
+	ctx := getContext(env)
+	defer blob.CloseStore(ctx, bs)
+
+	count := make(map[string]int64)
+	if err := bs.List(ctx, "", func(key string) error {
+		if key == "" {
+			count[""]++
+		} else {
+			count[key[:1]]++
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	pfxs := make([]string, 0, len(count))
+	for pfx := range count {
+		pfxs = append(pfxs, pfx)
+	}
+	sort.Strings(pfxs)
+	tw := tabwriter.NewWriter(os.Stdout, 4, 8, 1, ' ', 0)
+	fmt.Fprint(tw, "PREFIX\tKEYS\tDESCRIPTION\n")
+	for _, pfx := range pfxs {
+		desc := knownBuckets[pfx]
+		if desc == "" {
+			desc = "-"
+		}
+		fmt.Fprintf(tw, "%q\t%d\t%s\n", pfx, count[pfx], desc)
+	}
+	return tw.Flush()
+}
+
 func lenCmd(env *command.Env, args []string) error {
 	if len(args) != 0 {
 		return errors.New("usage is: len")
@@ -280,6 +429,52 @@ func statCmd(env *command.Env, args []string) error {
 	return nil
 }
 
+func configCmd(env *command.Env, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage is: config")
+	}
+	t := env.Config.(*settings)
+	t.Bucket = ""
+	s, err := storeFromEnv(env)
+	if err != nil {
+		return err
+	}
+	ctx := getContext(env)
+	defer blob.CloseStore(ctx, s)
+
+	cas, ok := s.(rpcstore.CAS)
+	if !ok {
+		return errors.New("server does not support the config command")
+	}
+
+	si, err := cas.ServerInfo(ctx)
+	if err != nil {
+		return err
+	}
+	cfg, ok := si.Label["blobd.config"].(map[string]interface{})
+	if !ok {
+		return errors.New("server did not report its configuration")
+	}
+	if t.JSON {
+		msg, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(msg))
+		return nil
+	}
+	keys := make([]string, 0, len(cfg))
+	for key := range cfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(os.Stdout, 4, 8, 1, ' ', 0)
+	for _, key := range keys {
+		fmt.Fprint(tw, key, ":\t", cfg[key], "\n")
+	}
+	return tw.Flush()
+}
+
 func putCmd(env *command.Env, args []string) (err error) {
 	if len(args) == 0 || len(args) > 2 {
 		return errors.New("usage is: put <key> [<path>]")
@@ -325,7 +520,7 @@ func storeFromEnv(env *command.Env) (blob.CAS, error) {
 		return nil, fmt.Errorf("no -store address was found (%q)", addr)
 	}
 
-	conn, err := net.Dial(jrpc2.Network(addr))
+	conn, err := t.FFS.Dial(t.FFS.DefaultStore)
 	if err != nil {
 		return nil, fmt.Errorf("dialing: %w", err)
 	}
@@ -0,0 +1,159 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffstools/internal/progress"
+	"github.com/creachadair/taskgroup"
+)
+
+func fsckCmd(env *command.Env, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage is: fsck")
+	}
+	cfg := env.Config.(*settings)
+	if cfg.Sample <= 0 || cfg.Sample > 1 {
+		return errors.New("the -sample value must be in (0, 1]")
+	} else if cfg.Workers <= 0 {
+		return errors.New("the -concurrency value must be positive")
+	}
+	start, err := parseKey(cfg.Start)
+	if err != nil {
+		return err
+	}
+	pfx, err := parseKey(cfg.Prefix)
+	if err != nil {
+		return err
+	}
+	if pfx != "" && start < pfx {
+		start = pfx
+	}
+	bs, err := storeFromEnv(env)
+	if err != nil {
+		return err
+	}
+	ctx := getContext(env)
+	defer blob.CloseStore(ctx, bs)
+
+	// Keys that are not the length of a content address cannot be one.
+	probe, err := bs.CASKey(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	began := time.Now()
+	prog := progress.NewCounter("fsck", 0)
+	defer progress.Notify(env, prog)()
+	stopBar := progress.Bar(os.Stderr, prog)
+	c := newCASChecker(ctx, bs, cfg.Workers, prog)
+	var numSkip int
+	if err := bs.List(ctx, start, func(key string) error {
+		if !strings.HasPrefix(key, pfx) {
+			if key > pfx {
+				return blob.ErrStopListing
+			}
+			return nil
+		} else if len(key) != len(probe) || rand.Float64() >= cfg.Sample {
+			numSkip++
+			return nil
+		}
+		prog.AddTotal(1)
+		c.check(key)
+		return nil
+	}); err != nil {
+		c.wait()
+		stopBar()
+		return err
+	}
+	bad, err := c.wait()
+	stopBar()
+	if err != nil {
+		return err
+	}
+	verdict := "PASS"
+	if len(bad) != 0 {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(env, "%s: checked %d blobs, skipped %d, found %d mismatched [%v elapsed]\n",
+		verdict, c.numChecked, numSkip, len(bad), time.Since(began).Truncate(10*time.Millisecond))
+	if len(bad) != 0 {
+		return fmt.Errorf("found %d blobs whose content does not match their key", len(bad))
+	}
+	return nil
+}
+
+// A casChecker concurrently verifies that the content of blobs matches their
+// content addresses.
+type casChecker struct {
+	ctx        context.Context
+	cas        blob.CAS
+	g          *taskgroup.Group
+	run        func(taskgroup.Task) *taskgroup.Group
+	prog       *progress.Counter
+	numChecked int64
+
+	mu  sync.Mutex
+	bad []string
+}
+
+func newCASChecker(ctx context.Context, cas blob.CAS, n int, prog *progress.Counter) *casChecker {
+	g, run := taskgroup.New(nil).Limit(n)
+	return &casChecker{ctx: ctx, cas: cas, g: g, run: run, prog: prog}
+}
+
+// check schedules a check of the blob stored under key. A mismatched key is
+// printed to stdout when found.
+func (c *casChecker) check(key string) {
+	c.run(func() error {
+		data, err := c.cas.Get(c.ctx, key)
+		if err != nil {
+			return fmt.Errorf("get %x: %w", key, err)
+		}
+		got, err := c.cas.CASKey(c.ctx, data)
+		if err != nil {
+			return fmt.Errorf("hashing %x: %w", key, err)
+		}
+		atomic.AddInt64(&c.numChecked, 1)
+		c.prog.Add(1)
+		if got != key {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.bad = append(c.bad, key)
+			fmt.Printf("mismatch %x (content hashes to %x)\n", key, got)
+		}
+		return nil
+	})
+}
+
+// wait waits for all scheduled checks to complete, and returns the keys whose
+// content did not match.
+func (c *casChecker) wait() ([]string, error) {
+	if err := c.g.Wait(); err != nil {
+		return nil, err
+	}
+	return c.bad, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/taskgroup"
+)
+
+func refreshCmd(env *command.Env, args []string) error {
+	cfg := env.Config.(*settings)
+	if len(args) == 0 && cfg.KeysFrom == "" {
+		//lint:ignore ST1005 The punctuation signifies repetition to the user.
+		return errors.New("usage is: refresh <key>... or refresh -keys <path>")
+	} else if cfg.Workers <= 0 {
+		return errors.New("the -concurrency value must be positive")
+	} else if cfg.Rate < 0 {
+		return errors.New("the -rate value must not be negative")
+	}
+	keys := args
+	if cfg.KeysFrom != "" {
+		more, err := readKeyList(cfg.KeysFrom)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, more...)
+	}
+	bs, err := storeFromEnv(env)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(getContext(env))
+	defer cancel()
+	defer blob.CloseStore(ctx, bs)
+
+	// With -rate, wait for a tick of the limiter before starting each blob.
+	var tick <-chan time.Time
+	if cfg.Rate > 0 {
+		interval := time.Duration(float64(time.Second) / cfg.Rate)
+		if interval <= 0 {
+			interval = 1 // rates above 1e9/s are effectively unlimited
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	start := time.Now()
+	var numDone, numMissing int64
+	g, run := taskgroup.New(taskgroup.Trigger(cancel)).Limit(cfg.Workers)
+	for _, arg := range keys {
+		key, err := parseKey(arg)
+		if err != nil {
+			cancel()
+			g.Wait()
+			return err
+		}
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+			case <-tick:
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		run(func() error {
+			data, err := bs.Get(ctx, key)
+			if blob.IsKeyNotFound(err) && cfg.MissingOK {
+				atomic.AddInt64(&numMissing, 1)
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("get %x: %w", key, err)
+			}
+			if err := bs.Put(ctx, blob.PutOptions{
+				Key:     key,
+				Data:    data,
+				Replace: true,
+			}); err != nil {
+				return fmt.Errorf("put %x: %w", key, err)
+			}
+			if v := atomic.AddInt64(&numDone, 1); v%1000 == 0 {
+				fmt.Fprint(os.Stderr, ".")
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	fmt.Fprintf(env, "Refreshed %d blobs, %d missing [%v elapsed]\n",
+		numDone, numMissing, time.Since(start).Truncate(10*time.Millisecond))
+	return nil
+}
+
+// readKeyList reads a list of keys, one per line, from the named file, or
+// from stdin if path == "-". Blank lines are ignored.
+func readKeyList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var keys []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys, sc.Err()
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+)
+
+func sampleCmd(env *command.Env, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage is: sample")
+	}
+	cfg := env.Config.(*settings)
+	if cfg.Count <= 0 {
+		return errors.New("the -n value must be positive")
+	}
+	pfx, err := parseKey(cfg.Prefix)
+	if err != nil {
+		return err
+	}
+	bs, err := storeFromEnv(env)
+	if err != nil {
+		return err
+	}
+	ctx := getContext(env)
+	defer blob.CloseStore(ctx, bs)
+
+	// Reservoir sampling: after seeing i keys, each of them has an equal
+	// chance to be among the candidates, and at most -n keys are retained.
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var sample []string
+	var seen int
+	if err := bs.List(ctx, pfx, func(key string) error {
+		if !strings.HasPrefix(key, pfx) {
+			if key > pfx {
+				return blob.ErrStopListing
+			}
+			return nil
+		}
+		seen++
+		if len(sample) < cfg.Count {
+			sample = append(sample, key)
+		} else if j := rng.Intn(seen); j < cfg.Count {
+			sample[j] = key
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Strings(sample)
+
+	for _, key := range sample {
+		if !cfg.Content {
+			size, err := bs.Size(ctx, key)
+			if err != nil {
+				return err
+			}
+			printSampleKey(key, cfg.Raw)
+			fmt.Printf("\t%d\n", size)
+			continue
+		}
+		data, err := bs.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		printSampleKey(key, cfg.Raw)
+		fmt.Printf("\t%d\n", len(data))
+		d := hex.Dumper(os.Stdout)
+		d.Write(data)
+		d.Close()
+	}
+	fmt.Fprintf(env, "Sampled %d of %d keys\n", len(sample), seen)
+	return nil
+}
+
+func printSampleKey(key string, raw bool) {
+	if raw {
+		fmt.Print(key)
+	} else {
+		fmt.Printf("%x", key)
+	}
+}
@@ -61,15 +61,17 @@ import (
 )
 
 var (
-	listenAddr = flag.String("listen", "", "Service address (required)")
+	listenAddr = flag.String("listen", "", "Service addresses, comma-separated (required)")
 	storeAddr  = flag.String("store", "", "Store address (required)")
 	keyFile    = flag.String("keyfile", "", "Encryption key file")
 	bufferDB   = flag.String("buffer", "", "Write-behind buffer database")
 	cacheSize  = flag.Int("cache", 0, "Memory cache size in MiB (0 means no cache)")
 	doDebug    = flag.Bool("debug", false, "Enable server debug logging")
 	zlibLevel  = flag.Int("zlib", 0, "Enable ZLIB compression (0 means no compression)")
+	zlibMargin = flag.Float64("compress-threshold", 0, "Store blobs uncompressed unless -zlib saves this fraction")
 	doVersion  = flag.Bool("version", false, "Print version information and exit")
 	serveMode  = flag.String("mode", "jrpc2", "Service mode (jrpc2 or chirp)")
+	metricsAdr = flag.String("metrics-addr", "", "Serve metrics over HTTP at this address")
 
 	// These storage implementations are built in by default.
 	// To include other stores, build with -tags set to their names.
@@ -91,18 +93,37 @@ func init() {
 
 Start a server that serves content from the blob.Store described by the -store spec.
 The server listens at the specified address, which may be a host:port or the path
-of a Unix-domain socket.
+of a Unix-domain socket. To listen at several addresses, separate them with commas.
 
 A store spec is a storage type and address: type:address
 The types understood are: %[2]s
 
-If -listen is a host:port address, a TCP listener is created at that address.
-Otherwise the address must be a path for a Unix-domain socket.
+The "exec" type runs an external helper program, exec:<command> [args...],
+that serves the Chirp store protocol on its stdin and stdout. This allows a
+custom backend to be used without rebuilding the server.
+
+If a -listen address is a host:port, a TCP listener is created at that address.
+Otherwise the address must be a path for a Unix-domain socket. All the listeners
+serve the same store, for example:
+
+  -listen /tmp/blobd.sock,localhost:9000
 JSON-RPC data are exchanged with each message on one line, ending with newline.
 
+With -zlib, blobs are compressed before they are stored. With -compress-threshold,
+a blob is stored uncompressed unless compression makes it smaller by at least
+the given fraction of its size (for example, 0.05 for 5%%). This avoids the cost
+of decompressing data that does not compress well, such as media and archives.
+Setting -compress-threshold changes the storage format, so it must be used
+consistently for a given store.
+
 With -keyfile, the store is opened with AES encryption.
 Use -cache to enable a memory cache over the underlying store.
 
+With -metrics-addr, an HTTP server is started at the given host:port that
+serves the server metrics as JSON at /debug/vars, and in the Prometheus
+text format at /metrics. In chirp mode only the server settings and the
+buffer length are reported, since call metrics are collected by jrpc2.
+
 Options:
 `, filepath.Base(os.Args[0]), strings.Join(keys, ", "))
 		flag.PrintDefaults()
@@ -115,10 +136,14 @@ func main() {
 		switch {
 		case *doVersion:
 			return printVersion()
-		case *listenAddr == "":
+		case len(parseAddrs(*listenAddr)) == 0:
 			ctrl.Exitf(1, "You must provide a non-empty -listen address")
 		case *storeAddr == "":
 			ctrl.Exitf(1, "You must provide a non-empty -store address")
+		case *zlibMargin < 0 || *zlibMargin >= 1:
+			ctrl.Exitf(1, "The -compress-threshold must be at least 0 and less than 1")
+		case *zlibMargin > 0 && *zlibLevel <= 0:
+			ctrl.Exitf(1, "The -compress-threshold requires -zlib")
 		}
 
 		ctx := context.Background()
@@ -131,6 +156,9 @@ func main() {
 		log.Printf("Store address: %q", *storeAddr)
 		if *zlibLevel > 0 {
 			log.Printf("Compression enabled: ZLIB level %d", *zlibLevel)
+			if *zlibMargin > 0 {
+				log.Printf("Compression threshold: %g", *zlibMargin)
+			}
 			if *keyFile != "" {
 				log.Printf(">> WARNING: Compression and encryption are both enabled")
 			}
@@ -143,28 +171,37 @@ func main() {
 		}
 
 		config := startConfig{
-			Address: *listenAddr,
+			Address: parseAddrs(*listenAddr),
 			Store:   bs,
 			Buffer:  buf,
 		}
+		config.Metrics = newMetrics(ctx, config)
+
+		// Start the metrics server first, so that if its address is not
+		// usable the program exits before the service listeners are open.
+		stopMetrics := func() {}
+		if *metricsAdr != "" {
+			stopMetrics = startMetricsServer(*metricsAdr, config.Metrics)
+		}
 
-		var closer closer
+		var closeService closer
 		var errc <-chan error
 		switch *serveMode {
 		case "jrpc", "jrpc2":
-			closer, errc = startJSONServer(ctx, config)
+			closeService, errc = startJSONServer(ctx, config)
 		case "chirp":
-			closer, errc = startChirpServer(ctx, config)
+			closeService, errc = startChirpServer(ctx, config)
 		default:
 			ctrl.Fatalf("Unknown service -mode %q", *serveMode)
 		}
+		closer := func() { stopMetrics(); closeService() }
 
 		sig := make(chan os.Signal, 2)
 		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 		go func() {
 			s, ok := <-sig
 			if ok {
-				log.Printf("Received signal: %v, closing listener", s)
+				log.Printf("Received signal: %v, closing listeners", s)
 				closer()
 				signal.Reset(syscall.SIGINT, syscall.SIGTERM)
 			}
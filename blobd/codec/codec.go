@@ -0,0 +1,102 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package codec provides blob encoding codecs for use with encoded stores.
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/creachadair/ffs/storage/encoded"
+)
+
+// Each encoded blob begins with a marker byte recording whether the rest of
+// the blob is stored as-is or encoded by the underlying codec.
+const (
+	markRaw     = 0
+	markEncoded = 1
+)
+
+// Threshold is an encoded.Codec that wraps a compressing codec, and stores a
+// blob uncompressed when compression does not save enough space to be worth
+// the cost of decompressing it.
+//
+// The encoding adds a one-byte marker to each blob, so it is not compatible
+// with data written by the underlying codec alone.
+type Threshold struct {
+	c      encoded.Codec
+	margin float64
+}
+
+// NewThreshold constructs a Threshold codec that delegates to c. A blob is
+// stored compressed only if its compressed size is smaller than its original
+// size by at least the given fraction (0 ≤ margin < 1) of the original.
+func NewThreshold(c encoded.Codec, margin float64) *Threshold {
+	return &Threshold{c: c, margin: margin}
+}
+
+// Encode implements part of the encoded.Codec interface.
+func (t *Threshold) Encode(w io.Writer, src []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(markEncoded)
+	if err := t.c.Encode(&buf, src); err != nil {
+		return err
+	}
+	limit := float64(len(src)) * (1 - t.margin)
+	if n := buf.Len() - 1; n < len(src) && float64(n) <= limit {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	if _, err := w.Write([]byte{markRaw}); err != nil {
+		return err
+	}
+	_, err := w.Write(src)
+	return err
+}
+
+// Decode implements part of the encoded.Codec interface.
+func (t *Threshold) Decode(w io.Writer, src []byte) error {
+	if len(src) == 0 {
+		return errMissingMarker
+	}
+	switch src[0] {
+	case markRaw:
+		_, err := w.Write(src[1:])
+		return err
+	case markEncoded:
+		return t.c.Decode(w, src[1:])
+	default:
+		return fmt.Errorf("invalid encoding marker %d", src[0])
+	}
+}
+
+// DecodedLen implements part of the encoded.Codec interface.
+func (t *Threshold) DecodedLen(src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, errMissingMarker
+	}
+	switch src[0] {
+	case markRaw:
+		return len(src) - 1, nil
+	case markEncoded:
+		return t.c.DecodedLen(src[1:])
+	default:
+		return 0, fmt.Errorf("invalid encoding marker %d", src[0])
+	}
+}
+
+var errMissingMarker = errors.New("missing encoding marker")
@@ -0,0 +1,80 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/ffs/storage/codecs/zlib"
+	"github.com/creachadair/ffstools/blobd/codec"
+)
+
+func TestThreshold(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	c := codec.NewThreshold(zlib.NewCodec(zlib.Level(6)), 0.1)
+	tests := []struct {
+		name       string
+		input      []byte
+		compressed bool
+	}{
+		{"Empty", nil, false},
+		{"Short", []byte("ok"), false},
+		{"Repetitive", []byte(strings.Repeat("all work and no play ", 200)), true},
+		{"Random", random, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var enc bytes.Buffer
+			if err := c.Encode(&enc, test.input); err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			encoded := enc.Bytes()
+			if got := encoded[0] != 0; got != test.compressed {
+				t.Errorf("Compressed: got %v, want %v", got, test.compressed)
+			}
+			if !test.compressed && !bytes.Equal(encoded[1:], test.input) {
+				t.Error("Uncompressed encoding does not match the input")
+			}
+
+			n, err := c.DecodedLen(encoded)
+			if err != nil {
+				t.Fatalf("DecodedLen failed: %v", err)
+			} else if n != len(test.input) {
+				t.Errorf("DecodedLen: got %d, want %d", n, len(test.input))
+			}
+
+			var dec bytes.Buffer
+			if err := c.Decode(&dec, encoded); err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			} else if !bytes.Equal(dec.Bytes(), test.input) {
+				t.Errorf("Decode: got %q, want %q", dec.Bytes(), test.input)
+			}
+		})
+	}
+}
+
+func TestThresholdInvalid(t *testing.T) {
+	c := codec.NewThreshold(zlib.NewCodec(zlib.Level(6)), 0)
+	for _, input := range []string{"", "\x02data", "\x01not zlib"} {
+		if err := c.Decode(new(bytes.Buffer), []byte(input)); err == nil {
+			t.Errorf("Decode(%q): got nil error, want error", input)
+		}
+	}
+}
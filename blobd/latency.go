@@ -0,0 +1,158 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/metrics"
+)
+
+// latencyBuckets are the inclusive upper bounds of the latency histogram
+// buckets. Calls slower than the last bound are counted in an overflow bucket.
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// latencyHist is a histogram of call latencies for a single method.
+type latencyHist struct {
+	start time.Time
+
+	mu     sync.Mutex
+	counts []int64 // one per bucket, plus overflow
+	n      int64
+	total  time.Duration
+	max    time.Duration
+}
+
+func newLatencyHist(start time.Time) *latencyHist {
+	return &latencyHist{
+		start:  start,
+		counts: make([]int64, len(latencyBuckets)+1),
+	}
+}
+
+func (h *latencyHist) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.n++
+	h.total += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// snapshot returns a JSON-friendly summary of the histogram, for use as the
+// value of a metrics label. The perSec field is the average number of calls per
+// second since the start time.
+func (h *latencyHist) snapshot() interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make(map[string]int64)
+	for i, n := range h.counts {
+		if n == 0 {
+			continue
+		} else if i < len(latencyBuckets) {
+			buckets["le:"+latencyBuckets[i].String()] = n
+		} else {
+			buckets["gt:"+latencyBuckets[i-1].String()] = n
+		}
+	}
+	var mean time.Duration
+	if h.n > 0 {
+		mean = h.total / time.Duration(h.n)
+	}
+	return map[string]interface{}{
+		"count":   h.n,
+		"perSec":  float64(h.n) / time.Since(h.start).Seconds(),
+		"mean":    mean.String(),
+		"max":     h.max.String(),
+		"buckets": buckets,
+	}
+}
+
+// timedAssigner wraps a jrpc2.Assigner to record the latency of each call in
+// a per-method histogram. The histogram for method m is published in the
+// metrics as the label "blobd.latency.m".
+type timedAssigner struct {
+	jrpc2.Assigner
+	mx    *metrics.M
+	start time.Time
+
+	mu   sync.Mutex
+	hist map[string]*latencyHist
+}
+
+func newTimedAssigner(a jrpc2.Assigner, mx *metrics.M, start time.Time) *timedAssigner {
+	return &timedAssigner{
+		Assigner: a,
+		mx:       mx,
+		start:    start,
+		hist:     make(map[string]*latencyHist),
+	}
+}
+
+// Assign implements the jrpc2.Assigner interface.
+func (t *timedAssigner) Assign(ctx context.Context, method string) jrpc2.Handler {
+	h := t.Assigner.Assign(ctx, method)
+	if h == nil {
+		return nil
+	}
+	return timedHandler{h: h, hist: t.histFor(method)}
+}
+
+// Names implements the jrpc2.Namer interface, if the underlying assigner does.
+func (t *timedAssigner) Names() []string {
+	if n, ok := t.Assigner.(jrpc2.Namer); ok {
+		return n.Names()
+	}
+	return nil
+}
+
+func (t *timedAssigner) histFor(method string) *latencyHist {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hist[method]
+	if !ok {
+		h = newLatencyHist(t.start)
+		t.hist[method] = h
+		t.mx.SetLabel("blobd.latency."+method, h.snapshot)
+	}
+	return h
+}
+
+type timedHandler struct {
+	h    jrpc2.Handler
+	hist *latencyHist
+}
+
+func (t timedHandler) Handle(ctx context.Context, req *jrpc2.Request) (interface{}, error) {
+	start := time.Now()
+	defer func() { t.hist.observe(time.Since(start)) }()
+	return t.h.Handle(ctx, req)
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/creachadair/ctrl"
+	"github.com/creachadair/jrpc2"
+)
+
+// parseAddrs splits a comma-separated list of listen addresses, discarding
+// empty entries.
+func parseAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// mustListen opens a listener for each of addrs, and returns a listener that
+// accepts connections from all of them. Closing the result closes all the
+// listeners, and removes the sockets of any Unix-domain listeners.
+func mustListen(addrs []string) net.Listener {
+	m := &multiListener{
+		conns: make(chan acceptResult),
+		done:  make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		lst, err := net.Listen(jrpc2.Network(addr))
+		if err != nil {
+			m.Close()
+			ctrl.Fatalf("Listen: %v", err)
+		}
+		if lst.Addr().Network() == "unix" {
+			os.Chmod(addr, 0600) // best-effort
+			m.sockets = append(m.sockets, addr)
+		}
+		m.lsts = append(m.lsts, lst)
+	}
+	for _, lst := range m.lsts {
+		go m.accept(lst)
+	}
+	return m
+}
+
+// A multiListener is a net.Listener that merges the connections accepted by
+// several underlying listeners.
+type multiListener struct {
+	lsts    []net.Listener
+	sockets []string // Unix-domain socket paths to remove on close
+	conns   chan acceptResult
+	done    chan struct{}
+	once    sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// accept forwards the connections accepted by lst to m until lst fails or m
+// is closed.
+func (m *multiListener) accept(lst net.Listener) {
+	for {
+		conn, err := lst.Accept()
+		select {
+		case m.conns <- acceptResult{conn, err}:
+			if err != nil {
+				return
+			}
+		case <-m.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// Accept implements part of net.Listener. It returns the next connection
+// accepted by any of the underlying listeners.
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.conns:
+		return r.conn, r.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements part of net.Listener. It closes all the underlying
+// listeners.
+func (m *multiListener) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+		for _, lst := range m.lsts {
+			lst.Close()
+		}
+		for _, path := range m.sockets {
+			os.Remove(path)
+		}
+	})
+	return nil
+}
+
+// Addr implements part of net.Listener. It returns the address of the first
+// underlying listener.
+func (m *multiListener) Addr() net.Addr { return m.lsts[0].Addr() }
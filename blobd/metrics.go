@@ -0,0 +1,122 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/ctrl"
+	"github.com/creachadair/jrpc2/metrics"
+)
+
+// startMetricsServer starts an HTTP server at addr that exports the contents
+// of mx. It returns a function that stops the server. If addr cannot be
+// listened on, startMetricsServer exits the program.
+func startMetricsServer(addr string, mx *metrics.M) closer {
+	lst, err := net.Listen("tcp", addr)
+	if err != nil {
+		ctrl.Fatalf("Metrics listener: %v", err)
+	}
+	expvar.Publish("blobd", expvar.Func(func() interface{} { return snapshot(mx) }))
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheus(w, mx)
+	})
+	srv := &http.Server{Handler: mux}
+
+	log.Printf("Metrics: http://%s/metrics", lst.Addr())
+	go func() {
+		if err := srv.Serve(lst); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Metrics server: %v", err)
+		}
+	}()
+	return func() { srv.Close() }
+}
+
+// writePrometheus writes the counters, maximum values, and numeric labels of
+// mx to w in the Prometheus text exposition format. Labels whose values are
+// not numeric or boolean are omitted.
+func writePrometheus(w io.Writer, mx *metrics.M) {
+	snap := snapshot(mx)
+
+	write := func(kind, name string, value interface{}) {
+		pname := promName(name)
+		fmt.Fprintf(w, "# TYPE %s %s\n%s %v\n", pname, kind, pname, value)
+	}
+	for _, name := range sortedKeys(snap.Counter) {
+		write("counter", name, snap.Counter[name])
+	}
+	for _, name := range sortedKeys(snap.MaxValue) {
+		write("gauge", name+".max", snap.MaxValue[name])
+	}
+	labels := make([]string, 0, len(snap.Label))
+	for name := range snap.Label {
+		labels = append(labels, name)
+	}
+	sort.Strings(labels)
+	for _, name := range labels {
+		switch v := snap.Label[name].(type) {
+		case int, int64, float64:
+			write("gauge", name, v)
+		case bool:
+			n := 0
+			if v {
+				n = 1
+			}
+			write("gauge", name, n)
+		}
+	}
+}
+
+// snapshot returns a snapshot of the current values of mx.
+func snapshot(mx *metrics.M) metrics.Snapshot {
+	snap := metrics.Snapshot{
+		Counter:  make(map[string]int64),
+		MaxValue: make(map[string]int64),
+		Label:    make(map[string]interface{}),
+	}
+	mx.Snapshot(snap)
+	return snap
+}
+
+// promName converts a metric name into a valid Prometheus metric name.
+func promName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
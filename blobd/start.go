@@ -22,8 +22,8 @@ import (
 	"hash"
 	"io"
 	"log"
-	"net"
 	"os"
+	"runtime/debug"
 	"time"
 
 	"github.com/creachadair/chirp"
@@ -36,6 +36,7 @@ import (
 	"github.com/creachadair/ffs/storage/codecs/zlib"
 	"github.com/creachadair/ffs/storage/encoded"
 	"github.com/creachadair/ffs/storage/wbstore"
+	"github.com/creachadair/ffstools/blobd/codec"
 	"github.com/creachadair/jrpc2"
 	"github.com/creachadair/jrpc2/channel"
 	"github.com/creachadair/jrpc2/metrics"
@@ -49,21 +50,17 @@ import (
 type closer = func()
 
 type startConfig struct {
-	Address string
+	Address []string
 	Store   blob.CAS
 	Buffer  blob.Store
+	Metrics *metrics.M
 }
 
 func startChirpServer(ctx context.Context, opts startConfig) (closer, <-chan error) {
-	lst, err := net.Listen(jrpc2.Network(opts.Address))
-	if err != nil {
-		ctrl.Fatalf("Listen: %v", err)
-	}
-	isUnix := lst.Addr().Network() == "unix"
-	if isUnix {
-		os.Chmod(opts.Address, 0600) // best-effort
+	lst := mustListen(opts.Address)
+	for _, addr := range opts.Address {
+		log.Printf("[chirp] Service: %q", addr)
 	}
-	log.Printf("[chirp] Service: %q", opts.Address)
 
 	service := chirpstore.NewService(opts.Store, nil)
 	errc := make(chan error, 1)
@@ -76,15 +73,43 @@ func startChirpServer(ctx context.Context, opts startConfig) (closer, <-chan err
 		})
 	}()
 
-	return func() {
-		lst.Close()
-		if isUnix {
-			defer os.Remove(opts.Address)
+	return func() { lst.Close() }, errc
+}
+
+// serverConfig returns a summary of the effective settings of the server, for
+// clients to inspect via the "blobd.config" metrics label.
+func serverConfig(opts startConfig) map[string]interface{} {
+	cfg := map[string]interface{}{
+		"store":      *storeAddr,
+		"listen":     opts.Address,
+		"mode":       *serveMode,
+		"encrypted":  *keyFile != "",
+		"compressed": *zlibLevel > 0,
+		"cacheMiB":   *cacheSize,
+		"buffered":   opts.Buffer != nil,
+	}
+	if *zlibLevel > 0 {
+		cfg["zlibLevel"] = *zlibLevel
+		if *zlibMargin > 0 {
+			cfg["compressThreshold"] = *zlibMargin
+		}
+	}
+	if *bufferDB != "" {
+		cfg["buffer"] = *bufferDB
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		cfg["goVersion"] = bi.GoVersion
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				cfg["revision"] = s.Value
+			}
 		}
-	}, errc
+	}
+	return cfg
 }
 
-func startJSONServer(ctx context.Context, opts startConfig) (closer, <-chan error) {
+// newMetrics returns a metrics collector with labels describing the server.
+func newMetrics(ctx context.Context, opts startConfig) *metrics.M {
 	mx := metrics.New()
 	mx.SetLabel("blobd.store", *storeAddr)
 	mx.SetLabel("blobd.pid", os.Getpid())
@@ -94,6 +119,7 @@ func startJSONServer(ctx context.Context, opts startConfig) (closer, <-chan erro
 	}
 	mx.SetLabel("blobd.compressed", *zlibLevel > 0)
 	mx.SetLabel("blobd.cacheSize", *cacheSize)
+	mx.SetLabel("blobd.config", serverConfig(opts))
 	if opts.Buffer != nil {
 		mx.SetLabel("blobd.buffer.db", *bufferDB)
 		mx.SetLabel("blobd.buffer.len", func() interface{} {
@@ -104,31 +130,32 @@ func startJSONServer(ctx context.Context, opts startConfig) (closer, <-chan erro
 			return n
 		})
 	}
+	return mx
+}
+
+func startJSONServer(ctx context.Context, opts startConfig) (closer, <-chan error) {
+	mx := opts.Metrics
 
 	var debug jrpc2.Logger
 	if *doDebug {
 		debug = jrpc2.StdLogger(log.New(os.Stderr, "[blobd] ", log.LstdFlags))
 	}
 
-	lst, err := net.Listen(jrpc2.Network(opts.Address))
-	if err != nil {
-		ctrl.Fatalf("Listen: %v", err)
-	}
-	isUnix := lst.Addr().Network() == "unix"
-	if isUnix {
-		os.Chmod(opts.Address, 0600) // best-effort
-	}
+	lst := mustListen(opts.Address)
 
-	service := rpcstore.NewService(opts.Store, nil).Methods()
+	startTime := time.Now().In(time.UTC)
+	service := newTimedAssigner(rpcstore.NewService(opts.Store, nil).Methods(), mx, startTime)
 	loopOpts := &server.LoopOptions{
 		ServerOptions: &jrpc2.ServerOptions{
 			Logger:    debug,
 			Metrics:   mx,
-			StartTime: time.Now().In(time.UTC),
+			StartTime: startTime,
 		},
 	}
 
-	log.Printf("[jrpc2] Service: %q", opts.Address)
+	for _, addr := range opts.Address {
+		log.Printf("[jrpc2] Service: %q", addr)
+	}
 	errc := make(chan error, 1)
 	go func() {
 		defer close(errc)
@@ -136,12 +163,7 @@ func startJSONServer(ctx context.Context, opts startConfig) (closer, <-chan erro
 		errc <- server.Loop(ctx, acc, server.Static(service), loopOpts)
 	}()
 
-	return func() {
-		lst.Close()
-		if isUnix {
-			defer os.Remove(opts.Address)
-		}
-	}, errc
+	return func() { lst.Close() }, errc
 }
 
 func mustOpenStore(ctx context.Context) (cas blob.CAS, buf blob.Store) {
@@ -169,7 +191,11 @@ func mustOpenStore(ctx context.Context) (cas blob.CAS, buf blob.Store) {
 		}
 	}
 	if *zlibLevel > 0 {
-		bs = encoded.New(bs, zlib.NewCodec(zlib.Level(*zlibLevel)))
+		var c encoded.Codec = zlib.NewCodec(zlib.Level(*zlibLevel))
+		if *zlibMargin > 0 {
+			c = codec.NewThreshold(c, *zlibMargin)
+		}
+		bs = encoded.New(bs, c)
 	}
 	if *keyFile == "" {
 		return blob.NewCAS(bs, sha3.New256), buf
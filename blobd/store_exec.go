@@ -0,0 +1,79 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/creachadair/chirp"
+	"github.com/creachadair/chirp/channel"
+	"github.com/creachadair/chirpstore"
+	"github.com/creachadair/ffs/blob"
+)
+
+func init() { stores["exec"] = execOpener }
+
+// execOpener opens a store backed by an external helper process.  The address
+// is a command line, split on whitespace; the program is started with the
+// remaining words as arguments and must serve the Chirp store protocol on its
+// stdin and stdout.  The helper's stderr is passed through to ours.
+func execOpener(_ context.Context, addr string) (blob.Store, error) {
+	args := strings.Fields(addr)
+	if len(args) == 0 {
+		return nil, errors.New("missing helper command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	peer := chirp.NewPeer().Start(channel.IO(out, in))
+	return execStore{
+		Store: chirpstore.NewStore(peer, nil),
+		peer:  peer,
+		cmd:   cmd,
+	}, nil
+}
+
+// execStore wraps a Chirp store client connected to a helper process, so that
+// closing the store also shuts down the helper.
+type execStore struct {
+	chirpstore.Store
+	peer *chirp.Peer
+	cmd  *exec.Cmd
+}
+
+// Close implements the blob.Closer interface.  Closing the peer closes the
+// helper's stdin, which signals it to exit.
+func (s execStore) Close(_ context.Context) error {
+	perr := s.peer.Stop()
+	werr := s.cmd.Wait()
+	if werr != nil {
+		return werr
+	}
+	return perr
+}
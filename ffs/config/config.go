@@ -19,19 +19,27 @@ package config
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
 	"path"
 	"strings"
 
+	"github.com/creachadair/atomicfile"
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/file"
 	"github.com/creachadair/ffs/file/root"
+	"github.com/creachadair/ffs/file/wiretype"
 	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffs/index"
 	"github.com/creachadair/ffs/storage/prefixed"
 	"github.com/creachadair/jrpc2"
 	"github.com/creachadair/jrpc2/channel"
@@ -58,32 +66,82 @@ type Settings struct {
 	Context context.Context `json:"-" yaml:"-"`
 
 	// The default address for the blob store service (required).  This must be
-	// either a store tag (@name) or an address.
+	// either a store tag (@name) or an address.  Environment variables in an
+	// address are expanded when it is used.
 	DefaultStore string `json:"defaultStore" yaml:"default-store"`
 
 	// Well-known store specifications, addressable by tag.
 	Stores []*StoreSpec `json:"stores" yaml:"stores"`
+
+	// If set, TLS settings used to connect to store addresses that are not
+	// given by tag. Tagged stores use the TLS settings of their spec.
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+
+	// If set, the default store is opened in-process from this spec instead
+	// of connecting to DefaultStore. This is not read from the config file.
+	Local *LocalStore `json:"-" yaml:"-"`
+
+	// If set, the default store is this already-open store, which is shared
+	// by all commands. WithStore does not close it. This is not read from the
+	// config file.
+	Shared blob.CAS `json:"-" yaml:"-"`
 }
 
-// A StoreSpec associates a tag (handle) with a storage address.
+// A StoreSpec associates a tag (handle) with a storage address.  Environment
+// variables in the address are expanded when the tag is resolved.
 type StoreSpec struct {
 	Tag     string `json:"tag" yaml:"tag"`
 	Address string `json:"address" yaml:"address"`
+
+	// If set, connect to the store using TLS with these settings.
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// TLSConfig records client settings for connecting to a store over TLS.
+// File paths are subject to environment variable expansion.
+type TLSConfig struct {
+	// The path of a PEM file containing CA certificates to trust.
+	// If empty, the system root certificates are used.
+	CAFile string `json:"caFile,omitempty" yaml:"ca-file,omitempty"`
+
+	// The paths of a PEM client certificate and its private key, to present
+	// for mutual TLS. If empty, no client certificate is sent.
+	CertFile string `json:"certFile,omitempty" yaml:"cert-file,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty" yaml:"key-file,omitempty"`
+
+	// The server name to verify. If empty, the host from the address is used.
+	ServerName string `json:"serverName,omitempty" yaml:"server-name,omitempty"`
+}
+
+// Config constructs a *tls.Config from the settings in c.
+func (c *TLSConfig) Config() (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: c.ServerName}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(os.ExpandEnv(c.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %q", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(os.ExpandEnv(c.CertFile), os.ExpandEnv(c.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
 }
 
 // ResolveAddress resolves the given address against the settings.  If addr is
 // of the form @tag and that tag exists in the settings, the expanded form of
 // the tag is returned; otherwise addr is returned unmodified.
 func (s *Settings) ResolveAddress(addr string) string {
-	if !strings.HasPrefix(addr, "@") {
-		return addr
-	}
-	tag := strings.TrimPrefix(addr, "@")
-	for _, st := range s.Stores {
-		if tag == st.Tag {
-			ExpandString(&st.Address)
-			return st.Address
-		}
+	if spec, ok := s.resolveSpec(addr); ok {
+		return spec.Address
 	}
 	return addr
 }
@@ -93,55 +151,109 @@ func (s *Settings) ResolveAddress(addr string) string {
 func (s *Settings) FindAddress() (string, bool) {
 	if s.DefaultStore == "" {
 		return "", false
-	} else if strings.HasPrefix(s.DefaultStore, "@") {
-		tag := strings.TrimPrefix(s.DefaultStore, "@")
-		for _, st := range s.Stores {
-			if tag == st.Tag {
-				ExpandString(&st.Address)
-				return st.Address, true
-			}
+	}
+	spec, ok := s.resolveSpec(s.DefaultStore)
+	return spec.Address, ok
+}
+
+// resolveSpec resolves addr against the settings. If addr is of the form @tag
+// and that tag exists, its spec is returned; if the tag does not exist, it
+// returns a spec with the tag as its address and false. Any other addr is
+// returned as a spec using the default TLS settings. Environment variables
+// in the resulting address are expanded.
+func (s *Settings) resolveSpec(addr string) (*StoreSpec, bool) {
+	if !strings.HasPrefix(addr, "@") {
+		return &StoreSpec{Address: os.ExpandEnv(addr), TLS: s.TLS}, true
+	}
+	tag := strings.TrimPrefix(addr, "@")
+	for _, st := range s.Stores {
+		if tag == st.Tag {
+			ExpandString(&st.Address)
+			return st, true
 		}
-		return tag, false
 	}
-	return s.DefaultStore, true
+	return &StoreSpec{Address: tag}, false
 }
 
-// OpenStore connects to the store service address in the configuration.  The
-// caller is responsible for closing the store when it is no longer needed.
-func (s *Settings) OpenStore() (blob.CAS, error) {
-	addr, ok := s.FindAddress()
+// Dial connects to the store service at addr, which may be an address or a
+// store tag (@name). If the selected store has TLS settings, the connection
+// uses TLS.
+func (s *Settings) Dial(addr string) (net.Conn, error) {
+	spec, ok := s.resolveSpec(addr)
 	if !ok {
-		return nil, fmt.Errorf("no store service address (%q)", addr)
+		return nil, fmt.Errorf("no store service address (%q)", spec.Address)
+	}
+	network, address := jrpc2.Network(spec.Address)
+	if spec.TLS == nil {
+		return net.Dial(network, address)
 	}
-	return OpenStore(s.Context, addr)
+	cfg, err := spec.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	return tls.Dial(network, address, cfg)
 }
 
-// OpenStore connects to the store service at addr.  The caller is responsible
-// for closing the store when it is no longer needed.
-func OpenStore(_ context.Context, addr string) (blob.CAS, error) {
-	conn, err := net.Dial(jrpc2.Network(addr))
+// OpenStore connects to the store service address in the configuration, or
+// opens the local store if one is set.  If a shared store is set, OpenStore
+// returns it, and the caller must not close it; otherwise the caller is
+// responsible for closing the store when it is no longer needed.  Use
+// WithStore to handle both cases.
+func (s *Settings) OpenStore() (blob.CAS, error) {
+	if s.Shared != nil {
+		return s.Shared, nil
+	} else if s.Local != nil {
+		return s.Local.Open(s.Context)
+	} else if s.DefaultStore == "" {
+		return nil, errors.New("no store service address")
+	}
+	return s.OpenStoreAddress(s.Context, s.DefaultStore)
+}
+
+// OpenStoreAddress connects to the store service at addr, which may be an
+// address or a store tag (@name).  The caller is responsible for closing the
+// store when it is no longer needed.
+func (s *Settings) OpenStoreAddress(_ context.Context, addr string) (blob.CAS, error) {
+	conn, err := s.Dial(addr)
 	if err != nil {
 		return nil, fmt.Errorf("dialing store: %w", err)
 	}
+	return newStore(conn), nil
+}
+
+// newStore constructs a store client that communicates over conn.
+func newStore(conn net.Conn) blob.CAS {
 	ch := channel.Line(conn, conn)
 	bs := rpcstore.NewCAS(jrpc2.NewClient(ch, nil), nil)
-	return prefixed.NewCAS(bs).Derive(" "), nil
+	return prefixed.NewCAS(bs).Derive(" ")
 }
 
 // WithStore calls f with a store opened from the configuration. The store is
 // closed after f returns. The error returned by f is returned by WithStore.
 func (s *Settings) WithStore(ctx context.Context, f func(blob.CAS) error) error {
-	addr, ok := s.FindAddress()
-	if !ok {
-		return fmt.Errorf("no store service address (%q)", addr)
+	if s.Shared != nil {
+		return f(s.Shared)
+	} else if s.Local != nil {
+		bs, err := s.Local.Open(ctx)
+		if err != nil {
+			return err
+		}
+		defer blob.CloseStore(ctx, bs)
+		return f(bs)
+	} else if s.DefaultStore == "" {
+		return errors.New("no store service address")
 	}
-	return WithStore(ctx, addr, f)
+	return s.WithStoreAddress(ctx, s.DefaultStore, f)
 }
 
-// WithStore calls f with a store opened at addr. The store is closed after f
-// returns. The error returned by f is returned by WithStore.
-func WithStore(ctx context.Context, addr string, f func(blob.CAS) error) error {
-	bs, err := OpenStore(ctx, addr)
+// WithStoreAddress calls f with a store opened at addr, which may be an
+// address or a store tag (@name). The store is closed after f returns. The
+// error returned by f is returned by WithStoreAddress.
+func (s *Settings) WithStoreAddress(ctx context.Context, addr string, f func(blob.CAS) error) error {
+	bs, err := s.OpenStoreAddress(ctx, addr)
 	if err != nil {
 		return err
 	}
@@ -152,6 +264,28 @@ func WithStore(ctx context.Context, addr string, f func(blob.CAS) error) error {
 // Roots derives a view of roots from bs.
 func Roots(bs blob.CAS) prefixed.CAS { return prefixed.NewCAS(bs).Derive("@") }
 
+// LoadIndex loads and decodes the blob index stored at key in s.
+func LoadIndex(ctx context.Context, s blob.CAS, key string) (*index.Index, error) {
+	var obj wiretype.Object
+	if err := wiretype.Load(ctx, s, key, &obj); err != nil {
+		return nil, fmt.Errorf("loading index: %w", err)
+	}
+	ridx := obj.GetIndex()
+	if ridx == nil {
+		return nil, fmt.Errorf("no index in %x", key)
+	}
+	return index.Decode(ridx)
+}
+
+// IndexFPR estimates the false-positive rate of an index with the given stats.
+func IndexFPR(st index.Stats) float64 {
+	if st.FilterBits == 0 {
+		return 0
+	}
+	k, n, m := float64(st.NumHashes), float64(st.NumKeys), float64(st.FilterBits)
+	return math.Pow(1-math.Exp(-k*n/m), k)
+}
+
 // ParseKey parses the string encoding of a key.  By default, s must be hex
 // encoded. If s begins with "@", it is taken literally. If s begins with "+"
 // it is taken as base64.
@@ -205,6 +339,25 @@ func PrintableKey(key string) interface{} {
 	return key
 }
 
+// WithOutput calls f with a writer for the output file at path. If path is ""
+// or "-", f writes to stdout. Otherwise, the output is written to a temporary
+// file that replaces path only if f succeeds, so a failed command does not
+// leave partial output behind.
+func WithOutput(path string, f func(io.Writer) error) error {
+	if path == "" || path == "-" {
+		return f(os.Stdout)
+	}
+	out, err := atomicfile.New(path, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f(out); err != nil {
+		out.Cancel()
+		return err
+	}
+	return out.Close()
+}
+
 // ToJSON converts a value to indented JSON.
 func ToJSON(msg interface{}) string {
 	bits, err := json.Marshal(msg)
@@ -262,10 +415,62 @@ func (p *PathInfo) Flush(ctx context.Context) (string, error) {
 // OpenPath parses and opens the specified path in s.
 // The path has either the form "@<root-key>/some/path" or "<file-key>/some/path".
 func OpenPath(ctx context.Context, s blob.CAS, path string) (*PathInfo, error) {
-	out := &PathInfo{Path: path}
+	first, rest := SplitPath(path)
+	base, err := openBase(ctx, s, first)
+	if err != nil {
+		return nil, err
+	}
+	base.Path = path
+	return base.openRest(ctx, rest)
+}
+
+// A PathCache opens paths like OpenPath, but caches the roots and base files
+// it loads so that repeated references to the same origin within a single
+// invocation do not reload them from the store.
+//
+// Paths opened through a PathCache share their base files, so a PathCache
+// should only be used by commands that do not modify the files they open.
+type PathCache struct {
+	s    blob.CAS
+	base map[string]*PathInfo // cached origins, keyed by root name or file key
+}
+
+// NewPathCache constructs a new empty PathCache that opens paths in s.
+func NewPathCache(s blob.CAS) *PathCache {
+	return &PathCache{s: s, base: make(map[string]*PathInfo)}
+}
 
+// OpenPath parses and opens the specified path, reusing a previously opened
+// origin if one is available. See OpenPath for the path syntax.
+func (c *PathCache) OpenPath(ctx context.Context, path string) (*PathInfo, error) {
 	first, rest := SplitPath(path)
 
+	// Normalize file keys so that different encodings share an entry.
+	id := first
+	if !strings.HasPrefix(first, "@") {
+		fk, err := ParseKey(first)
+		if err != nil {
+			return nil, err
+		}
+		id = fk
+	}
+	base, ok := c.base[id]
+	if !ok {
+		var err error
+		base, err = openBase(ctx, c.s, first)
+		if err != nil {
+			return nil, err
+		}
+		c.base[id] = base
+	}
+	cp := *base
+	cp.Path = path
+	return cp.openRest(ctx, rest)
+}
+
+// openBase opens the origin named by first, which is either "@<root-key>" or
+// the encoding of a file key.
+func openBase(ctx context.Context, s blob.CAS, first string) (*PathInfo, error) {
 	// Check for a @root key prefix.
 	if strings.HasPrefix(first, "@") {
 		rp, err := root.Open(ctx, Roots(s), first[1:])
@@ -276,37 +481,42 @@ func OpenPath(ctx context.Context, s blob.CAS, path string) (*PathInfo, error) {
 		if err != nil {
 			return nil, err
 		}
-		out.Root = rp
-		out.RootKey = first[1:]
-		out.Base = rf
-		out.File = rf
-		out.FileKey = rp.FileKey // provisional
+		return &PathInfo{
+			Root:    rp,
+			RootKey: first[1:],
+			Base:    rf,
+			File:    rf,
+			FileKey: rp.FileKey, // provisional
+		}, nil
+	}
 
-	} else if fk, err := ParseKey(first); err != nil {
+	fk, err := ParseKey(first)
+	if err != nil {
 		return nil, err
-
-	} else if fp, err := file.Open(ctx, s, fk); err != nil {
+	}
+	fp, err := file.Open(ctx, s, fk)
+	if err != nil {
 		return nil, err
-
-	} else {
-		out.Base = fp
-		out.File = fp
-		out.FileKey = fk
 	}
+	return &PathInfo{Base: fp, File: fp, FileKey: fk}, nil
+}
 
+// openRest resolves rest relative to the base of p, and updates p to refer to
+// the resulting file. It returns p.
+func (p *PathInfo) openRest(ctx context.Context, rest string) (*PathInfo, error) {
 	// If the rest of the path is empty, the starting point is the target.
 	if rest == "" {
-		return out, nil
+		return p, nil
 	}
 
 	// Otherwise, open a path relative to the base.
-	tf, err := fpath.Open(ctx, out.Base, rest)
+	tf, err := fpath.Open(ctx, p.Base, rest)
 	if err != nil {
 		return nil, err
 	}
-	out.File = tf
-	out.FileKey, _ = out.File.Flush(ctx) // safe, it was just opened
-	return out, nil
+	p.File = tf
+	p.FileKey, _ = p.File.Flush(ctx) // safe, it was just opened
+	return p, nil
 }
 
 // SplitPath parses s as a slash-separated path specification.
@@ -0,0 +1,230 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/server"
+	"github.com/creachadair/rpcstore"
+)
+
+// writeSelfSigned generates a self-signed certificate for localhost usable by
+// both clients and servers, and writes the certificate and key as PEM files in
+// dir. It returns the paths of the files.
+func writeSelfSigned(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Writing cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Writing key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSRoundTrip(t *testing.T) {
+	certFile, keyFile := writeSelfSigned(t, t.TempDir())
+
+	// Start a store service that requires a client certificate signed by the
+	// same self-signed authority.
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	lst, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer lst.Close()
+	svc := rpcstore.NewService(blob.NewCAS(memstore.New(), sha256.New), nil).Methods()
+	go server.Loop(ctx, server.NetAccepter(lst, channel.Line), server.Static(svc), nil)
+
+	cfg := &config.Settings{
+		Context:      ctx,
+		DefaultStore: "@secure",
+		Stores: []*config.StoreSpec{{
+			Tag:     "secure",
+			Address: lst.Addr().String(),
+			TLS: &config.TLSConfig{
+				CAFile:     certFile,
+				CertFile:   certFile,
+				KeyFile:    keyFile,
+				ServerName: "localhost",
+			},
+		}},
+	}
+
+	// A round trip through the tagged store should succeed.
+	if err := cfg.WithStore(ctx, func(s blob.CAS) error {
+		key, err := s.CASPut(ctx, []byte("hello, world"))
+		if err != nil {
+			return err
+		}
+		got, err := s.Get(ctx, key)
+		if err != nil {
+			return err
+		} else if string(got) != "hello, world" {
+			t.Errorf("Get %x: got %q, want %q", key, got, "hello, world")
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("TLS round trip failed: %v", err)
+	}
+
+	// Without a client certificate, the server should reject the caller.
+	cfg.Stores[0].TLS.CertFile = ""
+	cfg.Stores[0].TLS.KeyFile = ""
+	if err := cfg.WithStore(ctx, func(s blob.CAS) error {
+		_, err := s.CASPut(ctx, []byte("whatever"))
+		return err
+	}); err == nil {
+		t.Error("Round trip without a client certificate: got nil, want error")
+	}
+}
+
+func TestExpandAddress(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FFS_TEST_DIR", dir)
+
+	cfg := &config.Settings{
+		DefaultStore: "@test",
+		Stores: []*config.StoreSpec{
+			{Tag: "test", Address: "$FFS_TEST_DIR/tagged.sock"},
+		},
+	}
+	tests := []struct {
+		input, want string
+	}{
+		{"@test", dir + "/tagged.sock"},
+		{"$FFS_TEST_DIR/plain.sock", dir + "/plain.sock"},
+		{"localhost:${FFS_TEST_PORT}", "localhost:"},
+		{"@nonesuch", "@nonesuch"},
+	}
+	for _, tc := range tests {
+		if got := cfg.ResolveAddress(tc.input); got != tc.want {
+			t.Errorf("ResolveAddress(%q): got %q, want %q", tc.input, got, tc.want)
+		}
+	}
+	if got, ok := cfg.FindAddress(); !ok || got != dir+"/tagged.sock" {
+		t.Errorf("FindAddress: got (%q, %v), want (%q, true)", got, ok, dir+"/tagged.sock")
+	}
+}
+
+func TestExpandLocalSpec(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("FFS_TEST_DIR", dir)
+
+	ctx := context.Background()
+	ls := &config.LocalStore{Spec: "file:$FFS_TEST_DIR/store"}
+	s, err := ls.Open(ctx)
+	if err != nil {
+		t.Fatalf("Open %q: %v", ls.Spec, err)
+	}
+	defer blob.CloseStore(ctx, s)
+	if _, err := s.CASPut(ctx, []byte("hello")); err != nil {
+		t.Fatalf("CASPut: %v", err)
+	}
+
+	// The store should have been created at the expanded path.
+	if fi, err := os.Stat(filepath.Join(dir, "store")); err != nil {
+		t.Errorf("Expanded store path: %v", err)
+	} else if !fi.IsDir() {
+		t.Errorf("Expanded store path %q is not a directory", fi.Name())
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"4096", 4096},
+		{"64k", 64 << 10},
+		{"1.5M", 3 << 19},
+		{"2G", 2 << 30},
+	}
+	for _, tc := range tests {
+		got, err := config.ParseSize(tc.input)
+		if err != nil {
+			t.Errorf("ParseSize(%q): unexpected error: %v", tc.input, err)
+		} else if got != tc.want {
+			t.Errorf("ParseSize(%q): got %d, want %d", tc.input, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"K", "-1", "12X", "1e30G"} {
+		if got, err := config.ParseSize(bad); err == nil {
+			t.Errorf("ParseSize(%q): got %d, want error", bad, got)
+		}
+	}
+}
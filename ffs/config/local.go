@@ -0,0 +1,129 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+	"github.com/creachadair/ffs/storage/codecs/encrypted"
+	"github.com/creachadair/ffs/storage/codecs/zlib"
+	"github.com/creachadair/ffs/storage/encoded"
+	"github.com/creachadair/ffs/storage/filestore"
+	"github.com/creachadair/ffs/storage/prefixed"
+	"github.com/creachadair/ffstools/blobd/codec"
+	"github.com/creachadair/ffstools/blobd/store"
+	"github.com/creachadair/keyfile"
+	"golang.org/x/crypto/sha3"
+	"golang.org/x/term"
+)
+
+// LocalStores is the registry of storage implementations that can be opened
+// in-process by a LocalStore spec.
+var LocalStores = store.Registry{
+	"file":   filestore.Opener,
+	"memory": memstore.Opener,
+}
+
+// LocalStore describes a store opened directly in-process, rather than by
+// connecting to a store service. The options mirror those of blobd, and must
+// match the settings used to write the store.
+type LocalStore struct {
+	// The store spec, type:address, as understood by LocalStores.
+	// Environment variables anywhere in the spec are expanded.
+	Spec string
+
+	// If positive, blobs are ZLIB compressed at this level.
+	Zlib int
+
+	// If positive, blobs are compressed only when this saves at least this
+	// fraction of their size, as with the -compress-threshold flag of blobd.
+	ZlibMargin float64
+
+	// If set, the path of a key file used to encrypt blobs.
+	KeyFile string
+
+	// If true, writes and deletions are rejected.
+	ReadOnly bool
+}
+
+// errReadOnly is reported for writes to a read-only local store.
+var errReadOnly = errors.New("store is read-only")
+
+// Open opens the store described by ls. The caller is responsible for closing
+// the store when it is no longer needed.
+func (ls *LocalStore) Open(ctx context.Context) (blob.CAS, error) {
+	bs, err := LocalStores.Open(ctx, os.ExpandEnv(ls.Spec))
+	if err != nil {
+		return nil, err
+	}
+	if ls.ReadOnly {
+		bs = readOnlyStore{bs}
+	}
+	if ls.Zlib > 0 {
+		var c encoded.Codec = zlib.NewCodec(zlib.Level(ls.Zlib))
+		if ls.ZlibMargin > 0 {
+			c = codec.NewThreshold(c, ls.ZlibMargin)
+		}
+		bs = encoded.New(bs, c)
+	}
+	if ls.KeyFile == "" {
+		return prefixed.NewCAS(blob.NewCAS(bs, sha3.New256)).Derive(" "), nil
+	}
+
+	key, err := keyfile.LoadKey(os.ExpandEnv(ls.KeyFile), func() (string, error) {
+		io.WriteString(os.Stderr, "Passphrase: ")
+		bits, err := term.ReadPassword(0)
+		return string(bits), err
+	})
+	if err != nil {
+		blob.CloseStore(ctx, bs)
+		return nil, fmt.Errorf("loading encryption key: %w", err)
+	}
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		blob.CloseStore(ctx, bs)
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		blob.CloseStore(ctx, bs)
+		return nil, fmt.Errorf("creating GCM instance: %w", err)
+	}
+	bs = encoded.New(bs, encrypted.New(gcm, nil))
+	cas := blob.NewCAS(bs, func() hash.Hash {
+		return hmac.New(sha3.New256, key)
+	})
+	return prefixed.NewCAS(cas).Derive(" "), nil
+}
+
+// readOnlyStore wraps a blob.Store to reject modifications.
+type readOnlyStore struct{ blob.Store }
+
+func (readOnlyStore) Put(context.Context, blob.PutOptions) error { return errReadOnly }
+
+func (readOnlyStore) Delete(context.Context, string) error { return errReadOnly }
+
+// Close implements the blob.Closer interface.
+func (r readOnlyStore) Close(ctx context.Context) error { return blob.CloseStore(ctx, r.Store) }
@@ -0,0 +1,74 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// FormatSize formats n as a compact byte count using powers of 1024, as
+// "ls -h" does, for example "512", "1.5K", or "20M". Scaled sizes less than
+// 10 have one decimal place.
+func FormatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprint(n)
+	}
+	v := float64(n)
+	unit := -1
+	for v >= 1024 && unit < len(sizeUnits)-1 {
+		v /= 1024
+		unit++
+	}
+	if v < 10 {
+		return fmt.Sprintf("%.1f%c", v, sizeUnits[unit])
+	}
+	return fmt.Sprintf("%.0f%c", v, sizeUnits[unit])
+}
+
+const sizeUnits = "KMGTPE"
+
+// ParseSize parses a byte count, which may be fractional and may have a
+// suffix K, M, or G for units of 1024, 1024², or 1024³ bytes, for example
+// "4096", "64K", or "1.5G". An empty string is reported as 0.
+func ParseSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	scale := 1.0
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		scale = 1 << 10
+	case 'm', 'M':
+		scale = 1 << 20
+	case 'g', 'G':
+		scale = 1 << 30
+	}
+	num := s
+	if scale != 1 {
+		num = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	} else if v < 0 {
+		return 0, errors.New("size must not be negative")
+	} else if v*scale > math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(math.Round(v * scale)), nil
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/ffstools/internal/progress"
 
 	// Subcommands.
 	"github.com/creachadair/ffstools/ffs/internal/cmdexport"
@@ -30,13 +31,16 @@ import (
 	"github.com/creachadair/ffstools/ffs/internal/cmdindex"
 	"github.com/creachadair/ffstools/ffs/internal/cmdput"
 	"github.com/creachadair/ffstools/ffs/internal/cmdroot"
+	"github.com/creachadair/ffstools/ffs/internal/cmdshell"
 	"github.com/creachadair/ffstools/ffs/internal/cmdstatus"
 	"github.com/creachadair/ffstools/ffs/internal/cmdsync"
 )
 
 var (
-	configPath = config.Path()
-	storeAddr  string
+	configPath   = config.Path()
+	storeAddr    string
+	localStore   config.LocalStore
+	jsonProgress string
 )
 
 func main() {
@@ -44,11 +48,30 @@ func main() {
 		Name: filepath.Base(os.Args[0]),
 		Usage: `<command> [arguments]
 help [<command>]`,
-		Help: `A command-line tool to manage FFS file trees.`,
+		Help: `A command-line tool to manage FFS file trees.
+
+By default, commands connect to a store service. Use -store-spec to open a
+store directly in-process instead, for example -store-spec file:/path/to/dir.
+The -store-zlib, -store-compress-threshold, and -store-keyfile options must
+match the settings used by the server that wrote the store.
+
+With -json-progress, the put, export, sync, gc, and index commands write
+progress events to the named file ("-" for stderr), one JSON object per
+line, at most once per second for each phase:
+
+  {"phase":"sync copy","done":120,"total":500,"rate":60.5,"elapsedMs":1983}
+
+The total is omitted when it is not known in advance.`,
 
 		SetFlags: func(env *command.Env, fs *flag.FlagSet) {
 			fs.StringVar(&configPath, "config", configPath, "Configuration file path")
 			fs.StringVar(&storeAddr, "store", storeAddr, "Store service address (overrides config and environment)")
+			fs.StringVar(&localStore.Spec, "store-spec", "", "Open this store (type:address) directly instead of using a service")
+			fs.IntVar(&localStore.Zlib, "store-zlib", 0, "ZLIB compression level for -store-spec (0 means no compression)")
+			fs.Float64Var(&localStore.ZlibMargin, "store-compress-threshold", 0, "Compression threshold for -store-spec (see blobd -compress-threshold)")
+			fs.StringVar(&localStore.KeyFile, "store-keyfile", "", "Encryption key file for -store-spec")
+			fs.BoolVar(&localStore.ReadOnly, "store-readonly", false, "Open -store-spec read-only")
+			fs.StringVar(&jsonProgress, "json-progress", "", `Write JSON progress events to this file ("-" for stderr)`)
 		},
 
 		Init: func(env *command.Env) error {
@@ -61,6 +84,18 @@ help [<command>]`,
 			} else if bs := os.Getenv("FFS_STORE"); bs != "" {
 				cfg.DefaultStore = bs
 			}
+			if localStore.Spec != "" {
+				cfg.Local = &localStore
+			}
+			if jsonProgress == "-" {
+				progress.SetJSONStream(os.Stderr)
+			} else if jsonProgress != "" {
+				f, err := os.OpenFile(jsonProgress, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					return err
+				}
+				progress.SetJSONStream(f) // closed when the program exits
+			}
 			cfg.Context = context.Background()
 			config.ExpandString(&cfg.DefaultStore)
 			env.Config = cfg
@@ -76,6 +111,7 @@ help [<command>]`,
 			cmdindex.Command,
 			cmdsync.Command,
 			cmdstatus.Command,
+			cmdshell.Command,
 			command.HelpCommand(nil),
 		},
 	}
@@ -17,23 +17,34 @@ package cmdexport
 import (
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
+	"os/user"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/creachadair/atomicfile"
 	"github.com/creachadair/command"
 	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
 	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/file/wiretype"
 	"github.com/creachadair/ffs/fpath"
 	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/ffstools/internal/progress"
 	"github.com/creachadair/taskgroup"
 	"github.com/pkg/xattr"
+	"golang.org/x/crypto/sha3"
+	"google.golang.org/protobuf/proto"
 )
 
 var exportFlags struct {
@@ -42,8 +53,21 @@ var exportFlags struct {
 	Verbose bool
 	Target  string
 	Update  bool
+	Limit   int
+	NoEmpty bool
+	Link    bool
+	Keep    bool
+	Depth   int
+	Owner   bool
+	Strict  bool
+	DryRun  bool
+	Same    bool
 }
 
+// prefetchMaxSize is the largest file whose contents are prefetched.
+// Larger files are streamed from the store by their writer.
+const prefetchMaxSize = 1 << 20
+
 var Command = &command.C{
 	Name: "export",
 	Usage: `@<root-key>[/path/...]
@@ -54,7 +78,48 @@ Export a file tree to the local filesystem.
 Recursively export the file indicated by the selected root or file storage
 key to the path indicated by -to. By default, stat information (permissions,
 modification time, etc.) is copied to the output; use -nostat to omit this.
-Use -xattr to export extended attributes, if any are stored.`,
+Use -xattr to export extended attributes, if any are stored.
+Use -exclude-empty-dirs to skip directories that contain no files,
+either directly or in any of their subdirectories.
+
+With -hardlink, a file whose storage key matches one already exported is
+created as a hard link to the earlier copy rather than written again. If
+the link cannot be created, for example across filesystems, the file is
+copied instead.
+
+By default, export stops at the first error. With -continue-on-error, an
+error exporting a file is logged and the export continues; if a directory
+cannot be created, its contents are skipped. The paths that failed are
+listed at the end, and the command reports an error.
+
+With -prefetch n, the contents of up to n small files are fetched from
+the store ahead of the writers, so that store round trips overlap with
+writing to disk. This helps for trees of many small files on a store
+with high latency. Files larger than 1 MiB are not prefetched.
+
+With -skip-unchanged, which requires -update, a regular file whose output
+path already exists with the same size and contents is not written again.
+The contents are compared by hashing the local file into blocks and
+comparing their keys with those of the stored file, so the stored file
+is not read. If the store is not addressed by SHA3-256 (for example, if
+it is encrypted), each block key is computed by the store, so the local
+data are sent to it, though not stored. Its stat information is still
+restored, if requested.
+
+With -dry-run, the tree is walked and the action that would be taken for
+each entry is printed, but nothing is written. Entries whose output path
+already exists are marked as either overwritten (with -update) or failing,
+as the export itself would treat them.
+
+When stderr is a terminal, a progress bar is drawn during the export,
+unless -v is set. When the export is done, a summary of the files,
+bytes, symlinks, and directories written is printed.
+
+With -owner, the owner and group of each file are also restored, if stat
+information is stored. Stored user and group names are preferred, if they
+exist on the local system; otherwise the stored numeric IDs are used. This
+usually requires privilege, so a failure for lack of permission is logged
+as a warning and the export continues; with -strict it is an error.`,
 
 	SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
 		fs.BoolVar(&exportFlags.NoStat, "nostat", false, "Do not update permissions or modification times")
@@ -62,6 +127,15 @@ Use -xattr to export extended attributes, if any are stored.`,
 		fs.BoolVar(&exportFlags.Verbose, "v", false, "Enable verbose logging")
 		fs.BoolVar(&exportFlags.Update, "update", false, "Update target if it exists")
 		fs.StringVar(&exportFlags.Target, "to", "", "Export to this path (required)")
+		fs.BoolVar(&exportFlags.NoEmpty, "exclude-empty-dirs", false, "Do not create empty directories")
+		fs.BoolVar(&exportFlags.Link, "hardlink", false, "Hard link files with the same storage key")
+		fs.BoolVar(&exportFlags.Keep, "continue-on-error", false, "Log per-file errors and continue")
+		fs.IntVar(&exportFlags.Limit, "concurrency", 32, "Maximum number of concurrent file writes")
+		fs.BoolVar(&exportFlags.Same, "skip-unchanged", false, "With -update, do not rewrite files whose contents match")
+		fs.BoolVar(&exportFlags.DryRun, "dry-run", false, "Print what would be written without writing")
+		fs.BoolVar(&exportFlags.Owner, "owner", false, "Restore file owner and group")
+		fs.BoolVar(&exportFlags.Strict, "strict", false, "With -owner, fail if ownership cannot be restored")
+		fs.IntVar(&exportFlags.Depth, "prefetch", 0, "Number of small files to fetch ahead of the writers")
 	},
 	Run: runExport,
 }
@@ -73,6 +147,25 @@ func runExport(env *command.Env, args []string) error {
 		return env.Usagef("extra arguments: %q", args[1:])
 	} else if exportFlags.Target == "" {
 		return env.Usagef("missing required -to path")
+	} else if exportFlags.Limit < 1 {
+		return env.Usagef("the -concurrency value must be at least 1")
+	} else if exportFlags.Depth < 0 {
+		return env.Usagef("the -prefetch value must not be negative")
+	} else if exportFlags.Strict && !exportFlags.Owner {
+		return env.Usagef("-strict requires -owner")
+	} else if exportFlags.Same && !exportFlags.Update {
+		return env.Usagef("-skip-unchanged requires -update")
+	}
+
+	cfg := env.Config.(*config.Settings)
+	if exportFlags.DryRun {
+		return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+			of, err := config.OpenPath(cfg.Context, s, args[0])
+			if err != nil {
+				return err
+			}
+			return planExport(cfg.Context, env, of.File)
+		})
 	}
 
 	// Create leading components of the target directory path, as required.
@@ -80,7 +173,6 @@ func runExport(env *command.Env, args []string) error {
 		return err
 	}
 
-	cfg := env.Config.(*config.Settings)
 	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
 		of, err := config.OpenPath(cfg.Context, s, args[0])
 		if err != nil {
@@ -88,8 +180,30 @@ func runExport(env *command.Env, args []string) error {
 		}
 		cctx, cancel := context.WithCancel(cfg.Context)
 		defer cancel()
-		g, start := taskgroup.New(taskgroup.Trigger(cancel)).Limit(32)
+		g, start := taskgroup.New(taskgroup.Trigger(cancel)).Limit(exportFlags.Limit)
+		exportProgress.Phase("export", 0)
+		stats = exportStats{}
+		exported = newLinkSet()
+		ids = newIDCache()
+		hashStore = nil
+		if exportFlags.Same {
+			if hashStore, err = newHashStore(cfg.Context, s); err != nil {
+				return err
+			}
+		}
+		defer progress.Notify(env, exportProgress)()
+		stopBar := func() {}
+		if !exportFlags.Verbose {
+			stopBar = progress.Bar(os.Stderr, exportProgress)
+		}
+		begin := time.Now()
 
+		empties := make(emptyDirs)
+		var failed failures
+
+		// Each prefetched file holds a slot until it has been written, which
+		// bounds the amount of data buffered in memory.
+		slots := make(chan struct{}, exportFlags.Depth)
 		g.Go(func() error {
 			return fpath.Walk(cctx, of.File, func(e fpath.Entry) error {
 				if err := cctx.Err(); err != nil {
@@ -97,23 +211,207 @@ func runExport(env *command.Env, args []string) error {
 				}
 
 				opath := filepath.Join(exportFlags.Target, filepath.FromSlash(e.Path))
-				if !e.File.Stat().Mode.IsDir() {
-					start(func() error {
-						return exportFile(cctx, e.File, opath)
+				if e.Err != nil {
+					return failed.check(opath, e.Err)
+				} else if !e.File.Stat().Mode.IsDir() {
+					exportProgress.AddTotal(1)
+					if exportFlags.Depth == 0 || !e.File.Stat().Mode.IsRegular() || e.File.Size() > prefetchMaxSize {
+						start(func() error {
+							return failed.check(opath, exportFile(cctx, e.File, opath, nil))
+						})
+						return nil
+					}
+					select {
+					case <-cctx.Done():
+						return cctx.Err()
+					case slots <- struct{}{}:
+					}
+					g.Go(func() error {
+						data, err := io.ReadAll(e.File.Cursor(cctx))
+						if err != nil {
+							<-slots
+							return failed.check(opath, err)
+						}
+						start(func() error {
+							defer func() { <-slots }()
+							return failed.check(opath, exportFile(cctx, e.File, opath, data))
+						})
+						return nil
 					})
 					return nil
 				}
-				return exportFile(cctx, e.File, opath)
+				if exportFlags.NoEmpty && e.Path != "" {
+					if empty, err := empties.isEmpty(cctx, e.File); err != nil {
+						return err
+					} else if empty {
+						logPrintf("Skip empty directory %q", opath)
+						return fpath.ErrSkipChildren
+					}
+				}
+				exportProgress.AddTotal(1)
+				if err := failed.check(opath, exportFile(cctx, e.File, opath, nil)); err != nil {
+					return err
+				} else if failed.has(opath) {
+					return fpath.ErrSkipChildren
+				}
+				return nil
 			})
 		})
-		return g.Wait()
+		err = g.Wait()
+		stopBar()
+		if err != nil {
+			return err
+		}
+		stats.report(env, time.Since(begin))
+		return failed.report(env)
+	})
+}
+
+// planExport prints the action that exporting root would take for each entry,
+// without writing anything. Existing output paths are checked as exportFile
+// does, to predict whether each would be overwritten or fail.
+func planExport(ctx context.Context, w io.Writer, root *file.File) error {
+	empties := make(emptyDirs)
+	var nent, nover, nfail int
+	err := fpath.Walk(ctx, root, func(e fpath.Entry) error {
+		opath := filepath.Join(exportFlags.Target, filepath.FromSlash(e.Path))
+		if e.Err != nil {
+			return e.Err
+		}
+		mode := e.File.Stat().Mode
+		var action string
+		switch {
+		case mode.IsDir():
+			if exportFlags.NoEmpty && e.Path != "" {
+				if empty, err := empties.isEmpty(ctx, e.File); err != nil {
+					return err
+				} else if empty {
+					fmt.Printf("skip     %s (empty)\n", opath)
+					return fpath.ErrSkipChildren
+				}
+			}
+			action = "mkdir    " + opath
+		case mode.Type()&fs.ModeSymlink != 0:
+			target, err := io.ReadAll(e.File.Cursor(ctx))
+			if err != nil {
+				return fmt.Errorf("reading link target: %w", err)
+			}
+			action = fmt.Sprintf("symlink  %s -> %s", opath, target)
+		default:
+			action = fmt.Sprintf("write    %s (%d bytes)", opath, e.File.Size())
+		}
+		nent++
+
+		// N.B. A symlink cannot replace an existing path even with -update.
+		if _, err := os.Lstat(opath); err == nil {
+			if exportFlags.Update && mode.IsDir() {
+				action += " [exists]"
+			} else if exportFlags.Update && mode.Type()&fs.ModeSymlink == 0 {
+				action += " [exists, overwrite]"
+				nover++
+			} else {
+				action += " [exists, would fail]"
+				nfail++
+			}
+		}
+		fmt.Println(action)
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "DRY RUN: %d entries, %d existing would be overwritten, %d would fail\n",
+		nent, nover, nfail)
+	return nil
+}
+
+// failures records the paths that could not be exported, for
+// -continue-on-error.
+type failures struct {
+	mu    sync.Mutex
+	paths []string
+	isBad map[string]bool
+}
+
+// check reports err if -continue-on-error is not set. Otherwise, if err is
+// not nil, it logs and records the failure for path and returns nil.
+func (f *failures) check(path string, err error) error {
+	if err == nil || !exportFlags.Keep {
+		return err
+	}
+	log.Printf("Error: %v", err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.isBad == nil {
+		f.isBad = make(map[string]bool)
+	}
+	f.paths = append(f.paths, path)
+	f.isBad[path] = true
+	return nil
+}
+
+// has reports whether path has been recorded as a failure.
+func (f *failures) has(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.isBad[path]
 }
 
-func exportFile(ctx context.Context, f *file.File, path string) error {
+// report prints the recorded failures, if any, and reports an error.
+func (f *failures) report(w io.Writer) error {
+	if len(f.paths) == 0 {
+		return nil
+	}
+	sort.Strings(f.paths)
+	fmt.Fprintf(w, "Failed to export %d paths:\n", len(f.paths))
+	for _, path := range f.paths {
+		fmt.Fprintf(w, "  %s\n", path)
+	}
+	return fmt.Errorf("export incomplete: %d errors", len(f.paths))
+}
+
+// emptyDirs records which directories contain no non-directory files in their
+// subtrees, keyed by storage key.
+type emptyDirs map[string]bool
+
+// isEmpty reports whether the directory f and all its descendants contain no
+// files other than directories.
+func (m emptyDirs) isEmpty(ctx context.Context, f *file.File) (bool, error) {
+	key, err := f.Flush(ctx)
+	if err != nil {
+		return false, err
+	}
+	if v, ok := m[key]; ok {
+		return v, nil
+	}
+	empty := true
+	for _, name := range f.Child().Names() {
+		kid, err := f.Open(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		if !kid.Stat().Mode.IsDir() {
+			empty = false
+		} else if ok, err := m.isEmpty(ctx, kid); err != nil {
+			return false, err
+		} else if !ok {
+			empty = false
+		}
+		if !empty {
+			break
+		}
+	}
+	m[key] = empty
+	return empty, nil
+}
+
+// exportFile exports f to path. If data != nil, it holds the prefetched
+// contents of f.
+func exportFile(ctx context.Context, f *file.File, path string, data []byte) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
+	defer exportProgress.Add(1)
 	mode := f.Stat().Mode
 	var link bool
 	if mode.IsDir() {
@@ -122,12 +420,15 @@ func exportFile(ctx context.Context, f *file.File, path string) error {
 			if !exportFlags.Update || !os.IsExist(err) {
 				return err
 			}
+		} else {
+			atomic.AddInt64(&stats.dirs, 1)
 		}
 	} else if mode.Type()&fs.ModeSymlink != 0 {
 		logPrintf("Create symlink %q", path)
 		if err := linkFile(ctx, f, path); err != nil {
 			return err
 		}
+		atomic.AddInt64(&stats.symlinks, 1)
 		link = true
 	} else {
 		if !exportFlags.Update {
@@ -136,8 +437,25 @@ func exportFile(ctx context.Context, f *file.File, path string) error {
 				return fmt.Errorf("file %q exists", path)
 			}
 		}
-		logPrintf("Export %q", path)
-		if err := copyFile(ctx, f, path); err != nil {
+		if exportFlags.Same && mode.IsRegular() {
+			same, err := sameContent(ctx, f, path)
+			if err != nil {
+				return err
+			} else if same {
+				logPrintf("Skip unchanged %q", path)
+				atomic.AddInt64(&stats.skipped, 1)
+			} else if err := exportData(ctx, f, path, data); err != nil {
+				return err
+			}
+		} else if err := exportData(ctx, f, path, data); err != nil {
+			return err
+		}
+	}
+
+	// Restore ownership, if requested and available. This precedes setting
+	// permissions, since changing the owner may clear setuid and setgid bits.
+	if exportFlags.Owner && f.Stat().Persistent() {
+		if err := restoreOwner(path, f.Stat()); err != nil {
 			return err
 		}
 	}
@@ -154,8 +472,6 @@ func exportFile(ctx context.Context, f *file.File, path string) error {
 		if err := os.Chtimes(path, stat.ModTime, stat.ModTime); err != nil {
 			return fmt.Errorf("setting modtime: %w", err)
 		}
-
-		// TODO(creachadair): Maybe set owner/group?
 	}
 
 	// Restore extended attributes if requested.
@@ -174,10 +490,269 @@ func exportFile(ctx context.Context, f *file.File, path string) error {
 	return nil
 }
 
-func copyFile(ctx context.Context, f *file.File, path string) error {
-	r := bufio.NewReaderSize(f.Cursor(ctx), 1<<20)
-	_, err := atomicfile.WriteAll(path, r, 0600)
-	return err
+// restoreOwner sets the owner and group of path from stat. If the stored
+// owner or group name exists on the local system, its ID is used; otherwise
+// the stored numeric ID is used. A permission error is logged and ignored
+// unless -strict is set.
+func restoreOwner(path string, stat file.Stat) error {
+	uid, gid := ids.lookup(stat)
+	logPrintf("Restore %q owner %d and group %d", path, uid, gid)
+	err := os.Lchown(path, uid, gid)
+	if errors.Is(err, fs.ErrPermission) && !exportFlags.Strict {
+		log.Printf("Warning: cannot set owner of %q: %v", path, err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("setting owner: %w", err)
+	}
+	return nil
+}
+
+// ids caches the resolution of user and group names to local IDs, for -owner.
+var ids = newIDCache()
+
+func newIDCache() *idCache {
+	return &idCache{users: make(map[string]int), groups: make(map[string]int)}
+}
+
+type idCache struct {
+	mu     sync.Mutex
+	users  map[string]int // name → uid, or -1 if unknown
+	groups map[string]int // name → gid, or -1 if unknown
+}
+
+// lookup returns the local user and group IDs for the owner and group of
+// stat, preferring the stored names if they are known on this system.
+func (c *idCache) lookup(stat file.Stat) (uid, gid int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	uid, gid = stat.OwnerID, stat.GroupID
+	if name := stat.OwnerName; name != "" {
+		id, ok := c.users[name]
+		if !ok {
+			id = -1
+			if u, err := user.Lookup(name); err == nil {
+				if v, err := strconv.Atoi(u.Uid); err == nil {
+					id = v
+				}
+			}
+			c.users[name] = id
+		}
+		if id >= 0 {
+			uid = id
+		}
+	}
+	if name := stat.GroupName; name != "" {
+		id, ok := c.groups[name]
+		if !ok {
+			id = -1
+			if g, err := user.LookupGroup(name); err == nil {
+				if v, err := strconv.Atoi(g.Gid); err == nil {
+					id = v
+				}
+			}
+			c.groups[name] = id
+		}
+		if id >= 0 {
+			gid = id
+		}
+	}
+	return uid, gid
+}
+
+// sameContent reports whether path is a regular file with the same contents
+// as f. The local file is split and hashed into a file in hashStore, and its
+// block keys are compared to those of f, so the contents of f are not read.
+func sameContent(ctx context.Context, f *file.File, path string) (bool, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else if !fi.Mode().IsRegular() || fi.Size() != f.Size() {
+		return false, nil
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	local := file.New(hashStore, nil)
+	if err := local.SetData(ctx, in); err != nil {
+		return false, err
+	}
+	return proto.Equal(dataIndex(f), dataIndex(local)), nil
+}
+
+// dataIndex returns the index of the data blocks of f.
+func dataIndex(f *file.File) *wiretype.Index {
+	return file.Encode(f).Value.(*wiretype.Object_Node).Node.GetIndex()
+}
+
+// hashStore is the store into which -skip-unchanged hashes local files.
+var hashStore blob.CAS
+
+// newHashStore returns a store that assigns the same content addresses as s,
+// for hashing local files to compare with files in s. Data written to it are
+// discarded. If s uses the default SHA3-256 addressing, keys are computed
+// locally; otherwise (for example, if the store is encrypted) each key is
+// requested from s.
+func newHashStore(ctx context.Context, s blob.CAS) (blob.CAS, error) {
+	mem := blob.NewCAS(memstore.New(), sha3.New256)
+	probe := []byte("ffs export key probe")
+	want, err := s.CASKey(ctx, probe)
+	if err != nil {
+		return nil, err
+	}
+	if got, err := mem.CASKey(ctx, probe); err == nil && got == want {
+		return discardCAS{CAS: mem, keys: mem}, nil
+	}
+	return discardCAS{CAS: mem, keys: s}, nil
+}
+
+// discardCAS is a blob.CAS that assigns keys using another store, but does
+// not store any data.
+type discardCAS struct {
+	blob.CAS // an empty store, for other methods
+
+	keys blob.CAS
+}
+
+// CASPut implements part of blob.CAS. It returns the key for data, but does
+// not store it.
+func (d discardCAS) CASPut(ctx context.Context, data []byte) (string, error) {
+	return d.keys.CASKey(ctx, data)
+}
+
+// CASKey implements part of blob.CAS.
+func (d discardCAS) CASKey(ctx context.Context, data []byte) (string, error) {
+	return d.keys.CASKey(ctx, data)
+}
+
+// exportData writes the contents of f to path. If -hardlink is set and a file
+// with the same storage key has already been exported, path is linked to it.
+// If data != nil, it holds the prefetched contents of f.
+func exportData(ctx context.Context, f *file.File, path string, data []byte) error {
+	if !exportFlags.Link {
+		logPrintf("Export %q", path)
+		return copyFile(ctx, f, path, data)
+	}
+	key, err := f.Flush(ctx)
+	if err != nil {
+		return err
+	}
+	first, done := exported.claim(key, path)
+	if done == nil {
+		// This is the first file with this key; the caller writes it.
+		logPrintf("Export %q", path)
+		err := copyFile(ctx, f, path, data)
+		exported.finish(key, err)
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+	}
+	if exported.failed(key) {
+		logPrintf("Export %q", path)
+		return copyFile(ctx, f, path, data)
+	}
+	if err := os.Link(first, path); err != nil {
+		logPrintf("Linking %q failed: %v; copying", path, err)
+		return copyFile(ctx, f, path, data)
+	}
+	logPrintf("Link %q to %q", path, first)
+	atomic.AddInt64(&stats.hardlinks, 1)
+	return nil
+}
+
+// stats counts what was written by the export, for the summary.
+var stats exportStats
+
+type exportStats struct {
+	files, bytes, symlinks, hardlinks, dirs, skipped int64 // accessed atomically
+}
+
+// report prints a summary of s to w.
+func (s *exportStats) report(w io.Writer, elapsed time.Duration) {
+	msg := fmt.Sprintf("Exported %d files (%d bytes), %d symlinks, %d directories",
+		s.files, s.bytes, s.symlinks, s.dirs)
+	if s.hardlinks != 0 {
+		msg += fmt.Sprintf(", %d hard links", s.hardlinks)
+	}
+	if s.skipped != 0 {
+		msg += fmt.Sprintf(", %d unchanged", s.skipped)
+	}
+	fmt.Fprintf(w, "%s [%v elapsed]\n", msg, elapsed.Truncate(10*time.Millisecond))
+}
+
+// exportProgress counts the files and directories exported.
+var exportProgress = progress.NewCounter("export", 0)
+
+// exported records the paths of files exported by storage key, for -hardlink.
+var exported = newLinkSet()
+
+func newLinkSet() *linkSet { return &linkSet{m: make(map[string]*linkEntry)} }
+
+type linkSet struct {
+	mu sync.Mutex
+	m  map[string]*linkEntry
+}
+
+type linkEntry struct {
+	path string
+	done chan struct{} // closed when the first copy is complete
+	err  error
+}
+
+// claim records path as the first export of key, if key has not been seen,
+// and returns path and nil. Otherwise it returns the path of the first export
+// and a channel that is closed when it has been written.
+func (s *linkSet) claim(key, path string) (string, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.m[key]; ok {
+		return e.path, e.done
+	}
+	s.m[key] = &linkEntry{path: path, done: make(chan struct{})}
+	return path, nil
+}
+
+// finish marks the first export of key as complete with the given error.
+func (s *linkSet) finish(key string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.m[key]
+	e.err = err
+	close(e.done)
+}
+
+// failed reports whether the first export of key failed.
+func (s *linkSet) failed(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m[key].err != nil
+}
+
+func copyFile(ctx context.Context, f *file.File, path string, data []byte) error {
+	var nw int64
+	if data != nil {
+		if err := atomicfile.WriteData(path, data, 0600); err != nil {
+			return err
+		}
+		nw = int64(len(data))
+	} else {
+		r := bufio.NewReaderSize(f.Cursor(ctx), 1<<20)
+		n, err := atomicfile.WriteAll(path, r, 0600)
+		if err != nil {
+			return err
+		}
+		nw = n
+	}
+	atomic.AddInt64(&stats.files, 1)
+	atomic.AddInt64(&stats.bytes, nw)
+	return nil
 }
 
 func linkFile(ctx context.Context, f *file.File, path string) error {
@@ -0,0 +1,279 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffstools/ffs/config"
+)
+
+var chmodFlags struct {
+	Recursive bool
+}
+
+func runChmod(env *command.Env, args []string) error {
+	if len(args) < 2 {
+		return env.Usagef("got %d arguments, wanted mode, origin/path...", len(args))
+	}
+	apply, err := parseMode(args[0])
+	if err != nil {
+		return env.Usagef("invalid mode: %v", err)
+	}
+	return editStat(env, args[1:], func(st *file.Stat) {
+		st.Mode = fromUnixMode(apply(unixMode(st.Mode), st.Mode.IsDir()), st.Mode)
+	})
+}
+
+func runChown(env *command.Env, args []string) error {
+	if len(args) < 2 {
+		return env.Usagef("got %d arguments, wanted owner[:group], origin/path...", len(args))
+	}
+	owner, group, hasGroup := strings.Cut(args[0], ":")
+	var setOwner, setGroup func(*file.Stat)
+	if owner != "" {
+		id, name, err := lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("owner: %w", err)
+		}
+		setOwner = func(st *file.Stat) { st.OwnerID, st.OwnerName = id, name }
+	}
+	if hasGroup && group != "" {
+		id, name, err := lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("group: %w", err)
+		}
+		setGroup = func(st *file.Stat) { st.GroupID, st.GroupName = id, name }
+	}
+	if setOwner == nil && setGroup == nil {
+		return env.Usagef("missing owner and group")
+	}
+	return editStat(env, args[1:], func(st *file.Stat) {
+		if setOwner != nil {
+			setOwner(st)
+		}
+		if setGroup != nil {
+			setGroup(st)
+		}
+	})
+}
+
+// lookupID resolves s as a numeric ID or a name. A numeric ID is returned with
+// an empty name; otherwise lookup resolves the name to its numeric ID.
+func lookupID(s string, lookup func(string) (string, error)) (int, string, error) {
+	if v, err := strconv.Atoi(s); err == nil {
+		return v, "", nil
+	}
+	sid, err := lookup(s)
+	if err != nil {
+		return 0, "", err
+	}
+	v, err := strconv.Atoi(sid)
+	if err != nil {
+		return 0, "", fmt.Errorf("non-numeric ID for %q", s)
+	}
+	return v, s, nil
+}
+
+// editStat applies edit to the stat of each of the specified paths, or with
+// -R to every file beneath them, and updates their origins. Stat persistence
+// is enabled for each file edited.
+func editStat(env *command.Env, paths []string, edit func(*file.Stat)) error {
+	cfg := env.Config.(*config.Settings)
+	return withMutableStore(env, func(s blob.CAS) error {
+		for _, arg := range paths {
+			of, err := config.OpenPath(cfg.Context, s, arg)
+			if err != nil {
+				return err
+			}
+			update := func(f *file.File) {
+				st := f.Stat().Persist(true)
+				edit(&st)
+				st.Update()
+			}
+			if chmodFlags.Recursive {
+				var n int
+				if err := fpath.Walk(cfg.Context, of.File, func(e fpath.Entry) error {
+					if e.Err != nil {
+						return e.Err
+					}
+					update(e.File)
+					n++
+					return nil
+				}); err != nil {
+					return err
+				}
+				fmt.Fprintf(env, "Modified %d files under %q\n", n, arg)
+			} else {
+				update(of.File)
+			}
+			key, err := of.Flush(cfg.Context)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%x\n", key)
+		}
+		return nil
+	})
+}
+
+// Permission bits in the Unix encoding used by chmod.
+const (
+	bitSetuid = 04000
+	bitSetgid = 02000
+	bitSticky = 01000
+)
+
+// unixMode returns the permission bits of m in Unix encoding.
+func unixMode(m fs.FileMode) uint32 {
+	bits := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		bits |= bitSetuid
+	}
+	if m&fs.ModeSetgid != 0 {
+		bits |= bitSetgid
+	}
+	if m&fs.ModeSticky != 0 {
+		bits |= bitSticky
+	}
+	return bits
+}
+
+// fromUnixMode returns a copy of old with its permission bits replaced by the
+// Unix encoded bits.
+func fromUnixMode(bits uint32, old fs.FileMode) fs.FileMode {
+	m := old &^ (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	m |= fs.FileMode(bits & 0777)
+	if bits&bitSetuid != 0 {
+		m |= fs.ModeSetuid
+	}
+	if bits&bitSetgid != 0 {
+		m |= fs.ModeSetgid
+	}
+	if bits&bitSticky != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// parseMode parses a chmod mode, either an octal number or a comma-separated
+// list of symbolic clauses of the form [ugoa]*([-+=][rwxXst]*)+. It returns a
+// function that applies the mode to the Unix permission bits of a file.
+func parseMode(spec string) (func(bits uint32, isDir bool) uint32, error) {
+	if v, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if v > 07777 {
+			return nil, fmt.Errorf("mode %q out of range", spec)
+		}
+		return func(uint32, bool) uint32 { return uint32(v) }, nil
+	}
+
+	type clause struct {
+		who   uint32 // mask of bits affected
+		op    byte   // one of + - =
+		perms string // permission letters
+	}
+	var clauses []clause
+	for _, part := range strings.Split(spec, ",") {
+		var who uint32
+		i := 0
+		for ; i < len(part) && strings.IndexByte("ugoa", part[i]) >= 0; i++ {
+			switch part[i] {
+			case 'u':
+				who |= 04700
+			case 'g':
+				who |= 02070
+			case 'o':
+				who |= 01007
+			case 'a':
+				who |= 07777
+			}
+		}
+		if who == 0 {
+			who = 07777
+		}
+		if i == len(part) {
+			return nil, fmt.Errorf("missing operator in %q", part)
+		}
+		for i < len(part) {
+			op := part[i]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, fmt.Errorf("invalid character %q in %q", op, part)
+			}
+			j := i + 1
+			for ; j < len(part) && strings.IndexByte("rwxXst", part[j]) >= 0; j++ {
+			}
+			clauses = append(clauses, clause{who: who, op: op, perms: part[i+1 : j]})
+			i = j
+		}
+	}
+	if len(clauses) == 0 {
+		return nil, errors.New("empty mode")
+	}
+
+	return func(bits uint32, isDir bool) uint32 {
+		for _, c := range clauses {
+			var set uint32
+			for _, p := range c.perms {
+				switch p {
+				case 'r':
+					set |= 0444
+				case 'w':
+					set |= 0222
+				case 'x':
+					set |= 0111
+				case 'X':
+					if isDir || bits&0111 != 0 {
+						set |= 0111
+					}
+				case 's':
+					set |= bitSetuid | bitSetgid
+				case 't':
+					set |= bitSticky
+				}
+			}
+			set &= c.who
+			switch c.op {
+			case '+':
+				bits |= set
+			case '-':
+				bits &^= set
+			case '=':
+				bits = bits&^c.who | set
+			}
+		}
+		return bits
+	}, nil
+}
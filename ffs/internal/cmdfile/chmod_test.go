@@ -0,0 +1,65 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import "testing"
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		spec  string
+		bits  uint32
+		isDir bool
+		want  uint32
+	}{
+		// Octal modes replace all the bits.
+		{"644", 0777, false, 0644},
+		{"0755", 02644, true, 0755},
+		{"7777", 0, false, 07777},
+
+		// Symbolic modes.
+		{"u=rw,go-w", 0777, false, 0655},
+		{"u+x-w", 0644, false, 0544},
+		{"=r", 04777, false, 0444},
+		{"a+X", 0644, false, 0644},
+		{"a+X", 0744, false, 0755},
+		{"a+X", 0644, true, 0755},
+		{"o+s", 0644, false, 0644}, // setuid/setgid do not apply to others
+		{"u+s", 0644, false, 04644},
+		{"g+s", 0644, true, 02644},
+		{"+t", 0755, true, 01755},
+		{"o-t", 01777, true, 0777},
+		{"g=", 02775, true, 0705},
+		{"ug=rwx,o=", 0, true, 0770},
+	}
+	for _, tc := range tests {
+		apply, err := parseMode(tc.spec)
+		if err != nil {
+			t.Errorf("parseMode(%q): unexpected error: %v", tc.spec, err)
+			continue
+		}
+		if got := apply(tc.bits, tc.isDir); got != tc.want {
+			t.Errorf("parseMode(%q) on %04o (dir=%v): got %04o, want %04o",
+				tc.spec, tc.bits, tc.isDir, got, tc.want)
+		}
+	}
+}
+
+func TestParseModeErrors(t *testing.T) {
+	for _, spec := range []string{"", "u", "ux", "u+z", "a+r,", "10000", "u=r;g=w"} {
+		if _, err := parseMode(spec); err == nil {
+			t.Errorf("parseMode(%q): got nil error, want error", spec)
+		}
+	}
+}
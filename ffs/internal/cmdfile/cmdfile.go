@@ -16,16 +16,20 @@ package cmdfile
 
 import (
 	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/file"
 	"github.com/creachadair/ffs/file/wiretype"
 	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffs/storage/prefixed"
 	"github.com/creachadair/ffstools/ffs/config"
 )
 
@@ -52,11 +56,75 @@ a file may be specified in the following formats:
 
 			Run: runShow,
 		},
+		{
+			Name: "stat",
+			Usage: `@<root-key>[/path]
+<file-key>[/path]`,
+			Help: `Print the metadata of a file object as JSON
+
+This prints a single JSON object describing the file: its storage key,
+type, mode (symbolic and octal), size, number of data blocks, owner and
+group, modification time, number of children, and the names and sizes
+of its extended attributes. Owner and group names not recorded in the
+file are resolved from their IDs on the local system, if possible.
+`,
+
+			Run: runStat,
+		},
+		{
+			Name:  "list",
+			Usage: fileCmdUsage,
+			Help: `List the contents of directories
+
+For each origin, list the children of the origin if it is a directory,
+or else the origin itself. Use -long for a detailed listing, -json for
+one JSON object per entry, and -key to include storage keys. With -h,
+sizes in the long listing are shown in human-readable units (K, M, G, and
+so on, in powers of 1024); with -json, a "sizeHuman" field is added.
+
+With -R, list every directory in the subtree beneath each origin, each
+preceded by a header giving its path. In -json mode there are no headers;
+instead the name of each entry is its path relative to the origin.
+
+With -color, directories, symlinks, and executable files are colorized
+in the short and long listings. The value "auto" enables color only if
+stdout is a terminal and the NO_COLOR environment variable is not set.
+`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.BoolVar(&listFlags.Long, "long", false, "Print a detailed listing")
+				fs.BoolVar(&listFlags.JSON, "json", false, "Print entries as JSON")
+				fs.BoolVar(&listFlags.Key, "key", false, "Include storage keys")
+				fs.StringVar(&listFlags.Color, "color", "never", "Colorize output (auto, always, or never)")
+				fs.BoolVar(&listFlags.Human, "h", false, "Print sizes in human-readable units")
+				fs.BoolVar(&listFlags.Recursive, "R", false, "List subdirectories recursively")
+				fs.BoolVar(&listFlags.Recursive, "recursive", false, "List subdirectories recursively")
+			},
+			Run: runList,
+		},
 		{
 			Name:  "read",
 			Usage: fileCmdUsage,
-			Help:  "Read the binary contents of a file object",
+			Help: `Read the binary contents of a file object
+
+By default the contents are copied to stdout unmodified. Use -format hex
+to print a hex dump, or -format base64 to print the contents encoded as
+base64. It is an error to read a directory.
 
+Use -offset and -length to read only part of the file. A negative offset
+counts back from the end of the file. A length of 0 reads to the end of
+the file. If the offset is past the end of the file, nothing is read.
+
+Use -o to write the output to a file instead of stdout. The file is
+replaced only if the read succeeds.
+`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.StringVar(&readFlags.Format, "format", "raw", "Output format (raw, hex, base64)")
+				fs.StringVar(&readFlags.Output, "o", "-", `Write output to this file ("-" for stdout)`)
+				fs.Int64Var(&readFlags.Offset, "offset", 0, "Starting byte offset (negative counts from the end)")
+				fs.Int64Var(&readFlags.Length, "length", 0, "Maximum number of bytes to read (0 means to the end)")
+			},
 			Run: runRead,
 		},
 		{
@@ -67,9 +135,11 @@ a file may be specified in the following formats:
 
 The storage key of the modified origin is printed to stdout.
 If the origin is from a root, the root is updated with the modified origin.
+With -dry-run, the new key is computed but nothing is written to the store.
 `,
 
-			Run: runSet,
+			SetFlags: setDryRunFlag,
+			Run:      runSet,
 		},
 		{
 			Name: "remove",
@@ -79,24 +149,238 @@ If the origin is from a root, the root is updated with the modified origin.
 
 The storage key of the modified origin is printed to stdout.
 If the origin is from a root, the root is updated with the modified origin.
+With -dry-run, the new key is computed but nothing is written to the store.
+`,
+
+			SetFlags: setDryRunFlag,
+			Run:      runRemove,
+		},
+		{
+			Name: "mkdir",
+			Usage: `@<root-key>/<path> ...
+<origin-key>/<path> ...`,
+			Help: `Create empty directories beneath the origin
+
+Any missing directories along each path are also created. It is an error
+if the path already exists, unless -exists-ok is set and it is a directory.
+
+The storage key of each modified origin is printed to stdout once all the
+paths have been created.
+If the origin is from a root, the root is updated with the modified origin.
+With -dry-run, the new key is computed but nothing is written to the store.
+`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				setDryRunFlag(env, fs)
+				fs.BoolVar(&mkdirFlags.ExistsOK, "exists-ok", false, "Do not report an error if a directory exists")
+			},
+			Run: runMkdir,
+		},
+		{
+			Name:  "du",
+			Usage: fileCmdUsage,
+			Help: `Summarize the storage used by file trees
+
+For each origin, print the number of unique file nodes and the total
+stored size of the unique data blocks reachable from it. If the origin
+is a directory, a line is first printed for each of its children. Data
+shared between files is counted only once in each line, so the lines
+for the children may sum to more than the line for the directory.
+Given multiple origins, a final line reports the total for all of them.
+
+With -apparent, report instead the sum of the logical sizes of all the
+files, without regard to sharing.
+`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.BoolVar(&duFlags.Apparent, "apparent", false, "Report logical file sizes rather than storage")
+			},
+			Run: runDu,
+		},
+		{
+			Name: "edit",
+			Usage: `@<root-key>/<path>
+<origin-key>/<path>`,
+			Help: `Edit the contents of a file with $EDITOR
+
+The contents of the file are copied to a temporary file, and the program
+named by the EDITOR environment variable is run to edit it. If the editor
+exits successfully and the contents have changed, they are written back
+and the storage key of the modified origin is printed to stdout. If the
+origin is from a root, the root is updated with the modified origin.
+
+Only regular files no larger than -max-size bytes may be edited.
+With -dry-run, the new key is computed but nothing is written to the store.
+`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				setDryRunFlag(env, fs)
+				fs.Int64Var(&editFlags.MaxSize, "max-size", 1<<20, "Maximum size of file to edit, in bytes")
+			},
+			Run: runEdit,
+		},
+		{
+			Name: "chmod",
+			Usage: `<mode> @<root-key>/<path> ...
+<mode> <origin-key>/<path> ...`,
+			Help: `Change the permissions of files
+
+The mode is an octal number, or a comma-separated list of symbolic
+clauses as for chmod(1), such as "u+x", "go-w", or "a=rX". With -R, the
+mode is applied to every file beneath each path, including the path.
+The number of files modified is reported to stderr.
+
+The storage key of each modified origin is printed to stdout.
+If the origin is from a root, the root is updated with the modified origin.
+With -dry-run, the new key is computed but nothing is written to the store.
+`,
+
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				setDryRunFlag(env, fs)
+				fs.BoolVar(&chmodFlags.Recursive, "R", false, "Apply recursively to files beneath each path")
+			},
+			Run: runChmod,
+		},
+		{
+			Name: "chown",
+			Usage: `<owner>[:<group>] @<root-key>/<path> ...
+<owner>[:<group>] <origin-key>/<path> ...`,
+			Help: `Change the owner and group of files
+
+The owner and group may be numeric IDs or names; names are resolved to
+IDs on the local system. Either may be omitted to leave it unchanged,
+as in "alice" or ":staff". With -R, the change is applied to every file
+beneath each path, including the path.
+The number of files modified is reported to stderr.
+
+The storage key of each modified origin is printed to stdout.
+If the origin is from a root, the root is updated with the modified origin.
+With -dry-run, the new key is computed but nothing is written to the store.
 `,
 
-			Run: runRemove,
+			SetFlags: func(env *command.Env, fs *flag.FlagSet) {
+				setDryRunFlag(env, fs)
+				fs.BoolVar(&chmodFlags.Recursive, "R", false, "Apply recursively to files beneath each path")
+			},
+			Run: runChown,
+		},
+		{
+			Name:  "mtime-sort-report",
+			Usage: fileCmdUsage,
+			Help: `Report the most recently modified files beneath the origin
+
+Walk the tree rooted at the origin, and print the paths and modification
+times of the -n most recently modified non-directory files, newest first.
+Use -since to consider only files modified at or after the given time,
+which may be "now", "@<seconds>" since the Unix epoch, an age such as
+"7d" or "36h" before now, or RFC3339.
+`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.IntVar(&reportFlags.N, "n", 10, "Report at most this many files")
+				fs.StringVar(&reportFlags.Since, "since", "", "Consider only files modified since this time")
+			},
+			Run: runMtimeReport,
+		},
+		{
+			Name:  "stat-summary",
+			Usage: fileCmdUsage,
+			Help: `Summarize the metadata of files beneath the origin
+
+Walk the tree rooted at the origin, and report counts by file type and
+permission mode, the total, mean, and median size of regular files, and
+how many files have persistent stat and extended attributes. Only file
+metadata are read; file contents are not fetched.
+`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.BoolVar(&reportFlags.JSON, "json", false, "Write the summary as JSON")
+			},
+			Run: runStatSummary,
+		},
+		{
+			Name: "walk",
+			Usage: `<origin>[/path] ...
+-exec <origin>[/path] <command> [<arg>...]`,
+			Help: `Walk the tree rooted at each origin
+
+Print the path of each file beneath the origin, including the origin
+itself. Use -type to select only regular files (f), directories (d), or
+symbolic links (l), and -name to select files whose base name matches a
+glob pattern. Use -since and -until to select files modified at or after,
+or before, the given times; each may be "now", "@<seconds>" since the
+Unix epoch, an age such as "7d" or "36h" before now, or RFC3339.
+
+With -exec, the arguments after the origin are a command to run for each
+selected file. If any argument is exactly "{}", the contents of the file
+are written to a temporary file whose path replaces that argument;
+otherwise the contents are piped to the command's standard input. Up to
+-j commands run concurrently. A failing command is reported and the walk
+continues, unless -fatal is set.
+`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.BoolVar(&walkFlags.Exec, "exec", false, "Run a command for each selected file")
+				fs.StringVar(&walkFlags.Type, "type", "", "Select only files of this type (f, d, or l)")
+				fs.StringVar(&walkFlags.Name, "name", "", "Select only files whose base name matches this glob")
+				fs.StringVar(&walkFlags.Since, "since", "", "Select only files modified at or after this time")
+				fs.StringVar(&walkFlags.Until, "until", "", "Select only files modified before this time")
+				fs.IntVar(&walkFlags.Limit, "j", 1, "Maximum number of concurrent commands")
+				fs.BoolVar(&walkFlags.Fatal, "fatal", false, "Stop at the first failing command")
+			},
+			Run: runWalk,
 		},
 	},
 }
 
+var mutateFlags struct {
+	DryRun bool
+}
+
+func setDryRunFlag(_ *command.Env, fs *flag.FlagSet) {
+	fs.BoolVar(&mutateFlags.DryRun, "dry-run", false, "Report the resulting key without writing changes")
+}
+
+// withMutableStore calls f with a store opened from the configuration, into
+// which the caller may write changes. If -dry-run is set, writes to the store
+// are discarded after f returns.
+func withMutableStore(env *command.Env, f func(blob.CAS) error) error {
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		if !mutateFlags.DryRun {
+			return f(s)
+		}
+		// Capture writes beneath the key prefix, so that roots and other
+		// prefixed views of the store also see the captured writes.
+		pc, ok := s.(prefixed.CAS)
+		if !ok {
+			return errors.New("dry run is not supported for this store")
+		}
+		base, ok := pc.Base().(blob.CAS)
+		if !ok {
+			return errors.New("dry run is not supported for this store")
+		}
+		dry := prefixed.NewCAS(newDryRunCAS(base)).Derive(pc.Prefix())
+		if err := f(dry); err != nil {
+			return err
+		}
+		fmt.Fprintln(env, "Dry run: no changes were written")
+		return nil
+	})
+}
+
 func runShow(env *command.Env, args []string) error {
 	if len(args) == 0 {
 		return env.Usagef("missing required origin/path")
 	}
 	cfg := env.Config.(*config.Settings)
 	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		pc := config.NewPathCache(s)
 		for _, arg := range args {
 			if arg == "" {
 				return env.Usagef("origin may not be empty")
 			}
-			of, err := config.OpenPath(cfg.Context, s, arg)
+			of, err := pc.OpenPath(cfg.Context, arg)
 			if err != nil {
 				return err
 			}
@@ -115,18 +399,83 @@ func runRead(env *command.Env, args []string) error {
 	if len(args) == 0 {
 		return env.Usagef("missing required origin/path")
 	}
+	switch readFlags.Format {
+	case "raw", "hex", "base64":
+	default:
+		return env.Usagef("unknown -format %q", readFlags.Format)
+	}
+	if readFlags.Length < 0 {
+		return env.Usagef("the -length value must not be negative")
+	}
 	cfg := env.Config.(*config.Settings)
 	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
 		of, err := config.OpenPath(cfg.Context, s, args[0])
 		if err != nil {
 			return err
 		}
-		r := bufio.NewReaderSize(of.File.Cursor(cfg.Context), 1<<20)
-		_, err = io.Copy(os.Stdout, r)
-		return err
+		if of.File.Stat().Mode.IsDir() {
+			return fmt.Errorf("%q is a directory", args[0])
+		}
+		return config.WithOutput(readFlags.Output, func(out io.Writer) error {
+			var w io.WriteCloser
+			switch readFlags.Format {
+			case "raw":
+				w = nopCloser{out}
+			case "hex":
+				w = hex.Dumper(out)
+			case "base64":
+				w = newlineCloser{base64.NewEncoder(base64.StdEncoding, out), out}
+			}
+			// Clamp the requested range to the bounds of the file.
+			off, size := readFlags.Offset, of.File.Size()
+			if off < 0 {
+				off += size
+			}
+			if off < 0 {
+				off = 0
+			} else if off > size {
+				off = size
+			}
+			n := size - off
+			if readFlags.Length > 0 && readFlags.Length < n {
+				n = readFlags.Length
+			}
+			sr := io.NewSectionReader(of.File.Cursor(cfg.Context), off, n)
+			r := bufio.NewReaderSize(sr, 1<<20)
+			if _, err := io.Copy(w, r); err != nil {
+				return err
+			}
+			return w.Close()
+		})
 	})
 }
 
+var readFlags struct {
+	Format string
+	Output string
+	Offset int64
+	Length int64
+}
+
+// nopCloser wraps an io.Writer with a no-op Close method.
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// newlineCloser terminates its output with a newline to w when closed.
+type newlineCloser struct {
+	io.WriteCloser
+	w io.Writer
+}
+
+func (n newlineCloser) Close() error {
+	if err := n.WriteCloser.Close(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(n.w, "\n")
+	return err
+}
+
 func runSet(env *command.Env, args []string) error {
 	if len(args) != 2 {
 		return env.Usagef("got %d arguments, wanted origin/path, target", len(args))
@@ -141,7 +490,7 @@ func runSet(env *command.Env, args []string) error {
 	}
 
 	cfg := env.Config.(*config.Settings)
-	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+	return withMutableStore(env, func(s blob.CAS) error {
 		tf, err := file.Open(cfg.Context, s, targetKey)
 		if err != nil {
 			return fmt.Errorf("target file: %w", err)
@@ -177,7 +526,7 @@ func runRemove(env *command.Env, args []string) error {
 	}
 
 	cfg := env.Config.(*config.Settings)
-	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+	return withMutableStore(env, func(s blob.CAS) error {
 		for _, arg := range args {
 			base, rest := config.SplitPath(arg)
 			if rest == "" {
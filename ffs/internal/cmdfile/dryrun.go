@@ -0,0 +1,74 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"context"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
+)
+
+// dryRunCAS is a blob.CAS that reads through to a base store, but captures
+// all writes in memory so that the base store is never modified.
+type dryRunCAS struct {
+	blob.CAS // the base store, used for reads and key computation
+
+	mem *memstore.Store
+}
+
+func newDryRunCAS(base blob.CAS) dryRunCAS {
+	return dryRunCAS{CAS: base, mem: memstore.New()}
+}
+
+// Get implements part of blob.Store. Captured writes shadow the base store.
+func (d dryRunCAS) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := d.mem.Get(ctx, key)
+	if blob.IsKeyNotFound(err) {
+		return d.CAS.Get(ctx, key)
+	}
+	return data, err
+}
+
+// Put implements part of blob.Store. The write is captured in memory.
+func (d dryRunCAS) Put(ctx context.Context, opts blob.PutOptions) error {
+	opts.Replace = true
+	return d.mem.Put(ctx, opts)
+}
+
+// Size implements part of blob.Store. Captured writes shadow the base store.
+func (d dryRunCAS) Size(ctx context.Context, key string) (int64, error) {
+	n, err := d.mem.Size(ctx, key)
+	if blob.IsKeyNotFound(err) {
+		return d.CAS.Size(ctx, key)
+	}
+	return n, err
+}
+
+// Delete implements part of blob.Store. It affects only captured writes.
+func (d dryRunCAS) Delete(ctx context.Context, key string) error { return d.mem.Delete(ctx, key) }
+
+// CASPut implements part of blob.CAS. The key is computed by the base store,
+// but the write is captured in memory.
+func (d dryRunCAS) CASPut(ctx context.Context, data []byte) (string, error) {
+	key, err := d.CAS.CASKey(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	return key, d.Put(ctx, blob.PutOptions{Key: key, Data: data})
+}
+
+// Close implements blob.Closer. It does not close the base store.
+func (d dryRunCAS) Close(context.Context) error { return nil }
@@ -0,0 +1,191 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/taskgroup"
+)
+
+var duFlags struct {
+	Apparent bool
+}
+
+func runDu(env *command.Env, args []string) error {
+	if len(args) == 0 {
+		return env.Usagef("missing required origin/path")
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		tw := tabwriter.NewWriter(os.Stdout, 4, 8, 1, ' ', tabwriter.AlignRight)
+		fmt.Fprint(tw, "FILES\tBYTES\t\n")
+
+		du := &diskUsage{s: s, sizes: make(map[string]int64)}
+		all := newUsageSet()
+		for _, arg := range args {
+			of, err := config.OpenPath(cfg.Context, s, arg)
+			if err != nil {
+				return err
+			}
+
+			// Report each child of a directory separately, then the total.
+			type row struct {
+				name string
+				set  *usageSet
+			}
+			var rows []row
+			if of.File.Stat().Mode.IsDir() {
+				for _, name := range of.File.Child().Names() {
+					kid, err := of.File.Open(cfg.Context, name)
+					if err != nil {
+						return err
+					}
+					set, err := du.scan(cfg.Context, kid)
+					if err != nil {
+						return fmt.Errorf("scanning %q: %w", path.Join(arg, name), err)
+					}
+					rows = append(rows, row{path.Join(arg, name), set})
+				}
+			}
+			set, err := du.scan(cfg.Context, of.File)
+			if err != nil {
+				return fmt.Errorf("scanning %q: %w", arg, err)
+			}
+			rows = append(rows, row{arg, set})
+			all.merge(set)
+
+			for _, r := range rows {
+				fmt.Fprintf(tw, "%d\t%d\t %s\n", len(r.set.files), du.total(r.set), r.name)
+			}
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(tw, "%d\t%d\t %s\n", len(all.files), du.total(all), "total")
+		}
+		return tw.Flush()
+	})
+}
+
+// A usageSet records the files and data blocks of a subtree. In apparent mode,
+// blocks is unused and apparent is the sum of the file sizes.
+type usageSet struct {
+	files    map[string]bool
+	blocks   map[string]bool
+	apparent int64
+}
+
+func newUsageSet() *usageSet {
+	return &usageSet{files: make(map[string]bool), blocks: make(map[string]bool)}
+}
+
+func (u *usageSet) merge(v *usageSet) {
+	for key := range v.files {
+		u.files[key] = true
+	}
+	for key := range v.blocks {
+		u.blocks[key] = true
+	}
+	u.apparent += v.apparent
+}
+
+// diskUsage computes the storage used by file trees, caching the stored sizes
+// of data blocks.
+type diskUsage struct {
+	s     blob.CAS
+	sizes map[string]int64 // block key → stored size
+}
+
+// scan returns the usage of the tree rooted at f.
+func (d *diskUsage) scan(ctx context.Context, f *file.File) (*usageSet, error) {
+	set := newUsageSet()
+	if duFlags.Apparent {
+		err := fpath.Walk(ctx, f, func(e fpath.Entry) error {
+			if e.Err != nil {
+				return e.Err
+			}
+			key, err := e.File.Flush(ctx)
+			if err != nil {
+				return err
+			}
+			set.files[key] = true
+			set.apparent += e.File.Size()
+			return nil
+		})
+		return set, err
+	}
+
+	if err := f.Scan(ctx, func(key string, isFile bool) bool {
+		if isFile {
+			if set.files[key] {
+				return false // already counted
+			}
+			set.files[key] = true
+		} else {
+			set.blocks[key] = true
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	// Fetch the sizes of any blocks not already known.
+	var need []string
+	for key := range set.blocks {
+		if _, ok := d.sizes[key]; !ok {
+			need = append(need, key)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	g, run := taskgroup.New(taskgroup.Trigger(cancel)).Limit(64)
+	for _, key := range need {
+		key := key
+		run(func() error {
+			n, err := d.s.Size(ctx, key)
+			if err != nil {
+				return fmt.Errorf("size of %x: %w", key, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			d.sizes[key] = n
+			return nil
+		})
+	}
+	return set, g.Wait()
+}
+
+// total returns the total size of the blocks in u, or its apparent size.
+func (d *diskUsage) total(u *usageSet) int64 {
+	if duFlags.Apparent {
+		return u.apparent
+	}
+	var sum int64
+	for key := range u.blocks {
+		sum += d.sizes[key]
+	}
+	return sum
+}
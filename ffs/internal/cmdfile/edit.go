@@ -0,0 +1,105 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffstools/ffs/config"
+)
+
+var editFlags struct {
+	MaxSize int64
+}
+
+func runEdit(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return env.Usagef("got %d arguments, wanted origin/path", len(args))
+	}
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		return errors.New("the EDITOR environment variable is not set")
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return withMutableStore(env, func(s blob.CAS) error {
+		of, err := config.OpenPath(cfg.Context, s, args[0])
+		if err != nil {
+			return err
+		}
+		st := of.File.Stat()
+		if st.Mode.IsDir() {
+			return fmt.Errorf("%q is a directory", args[0])
+		} else if !st.Mode.IsRegular() {
+			return fmt.Errorf("%q is not a regular file", args[0])
+		} else if n := of.File.Size(); n > editFlags.MaxSize {
+			return fmt.Errorf("%q is too large to edit (%d > %d bytes)", args[0], n, editFlags.MaxSize)
+		}
+		old, err := io.ReadAll(of.File.Cursor(cfg.Context))
+		if err != nil {
+			return err
+		}
+
+		// Copy the contents to a temporary file, and let the user edit it.
+		tmp, err := os.CreateTemp("", "ffs-edit-*-"+path.Base(args[0]))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = tmp.Write(old)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("editor failed; not saving changes: %w", err)
+		}
+		data, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return err
+		} else if bytes.Equal(data, old) {
+			fmt.Fprintln(env, "No changes")
+			return nil
+		}
+
+		// Write the modified contents back and update the origin.
+		if err := of.File.SetData(cfg.Context, bytes.NewReader(data)); err != nil {
+			return err
+		}
+		st = of.File.Stat()
+		st.ModTime = time.Now()
+		st.Update()
+		key, err := of.Flush(cfg.Context)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%x\n", key)
+		return nil
+	})
+}
@@ -0,0 +1,275 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffstools/ffs/config"
+	"golang.org/x/term"
+)
+
+var listFlags struct {
+	Long      bool
+	JSON      bool
+	Key       bool
+	Color     string
+	Recursive bool
+	Human     bool
+}
+
+func runList(env *command.Env, args []string) error {
+	if len(args) == 0 {
+		return env.Usagef("missing required origin/path")
+	}
+	color, err := useColor(listFlags.Color)
+	if err != nil {
+		return env.Usagef("%v", err)
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		tw := tabwriter.NewWriter(os.Stdout, 2, 8, 1, ' ', 0)
+		defer tw.Flush()
+
+		pc := config.NewPathCache(s)
+		first := true
+		for _, arg := range args {
+			of, err := pc.OpenPath(cfg.Context, arg)
+			if err != nil {
+				return err
+			}
+
+			// A non-directory is listed by itself.
+			if !of.File.Stat().Mode.IsDir() {
+				if err := printOne(cfg.Context, tw, of.File, of.File.Name(), color); err != nil {
+					return err
+				}
+				continue
+			}
+			if !listFlags.Recursive {
+				if err := listDir(cfg.Context, tw, of.File, "", color); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// With -R, list each directory in the subtree in turn. JSON
+			// entries carry their relative path in place of a header.
+			if err := fpath.Walk(cfg.Context, of.File, func(e fpath.Entry) error {
+				if e.Err != nil {
+					return e.Err
+				} else if !e.File.Stat().Mode.IsDir() {
+					return nil
+				}
+				if listFlags.JSON {
+					return listDir(cfg.Context, tw, e.File, e.Path, color)
+				}
+				if !first {
+					fmt.Fprintln(tw)
+				}
+				first = false
+				fmt.Fprintf(tw, "%s:\n", path.Join(arg, e.Path))
+				return listDir(cfg.Context, tw, e.File, "", color)
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// listDir prints a listing of each child of dir to w. If prefix != "", it is
+// joined to the name of each child.
+func listDir(ctx context.Context, w io.Writer, dir *file.File, prefix string, color bool) error {
+	for _, name := range dir.Child().Names() {
+		kid, err := dir.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		if err := printOne(ctx, w, kid, path.Join(prefix, name), color); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printOne prints a listing of f under the given name to w, in the format
+// selected by the list flags.
+func printOne(ctx context.Context, w io.Writer, f *file.File, name string, color bool) error {
+	key, err := f.Flush(ctx)
+	if err != nil {
+		return err
+	}
+	if listFlags.JSON {
+		msg, err := jsonFormat(ctx, f, name, key)
+		if err != nil {
+			return err
+		}
+		bits, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(bits))
+		return err
+	}
+
+	mode := f.Stat().Mode
+	if color {
+		name = colorName(name, mode)
+	}
+	if mode&fs.ModeSymlink != 0 && listFlags.Long {
+		target, err := readTarget(ctx, f)
+		if err != nil {
+			return err
+		}
+		name += " -> " + target
+	}
+	if listFlags.Key {
+		name = fmt.Sprintf("%x\t%s", key, name)
+	}
+	if listFlags.Long {
+		_, err = fmt.Fprintln(w, listFormat(f, name))
+	} else {
+		_, err = fmt.Fprintln(w, name)
+	}
+	return err
+}
+
+// listFormat formats a long listing entry for f under the given name.
+func listFormat(f *file.File, name string) string {
+	st := f.Stat()
+	var nx int
+	f.XAttr().List(func(string, string) { nx++ })
+	xmark := " "
+	if nx != 0 {
+		xmark = "@" // has extended attributes
+	}
+	size := fmt.Sprintf("%9d", f.Size())
+	if listFlags.Human {
+		size = fmt.Sprintf("%5s", config.FormatSize(f.Size()))
+	}
+	return fmt.Sprintf("%s%s\t%s\t%s\t%s\t%s\t%s",
+		st.Mode, xmark, ident(st.OwnerName, st.OwnerID), ident(st.GroupName, st.GroupID),
+		size, st.ModTime.Format(time.Stamp), name)
+}
+
+// listEntry is the JSON encoding of a list entry.
+type listEntry struct {
+	Name    string            `json:"name"`
+	Key     []byte            `json:"key"`
+	Mode    string            `json:"mode"`
+	Size    int64             `json:"size"`
+	SizeH   string            `json:"sizeHuman,omitempty"`
+	ModTime time.Time         `json:"modTime,omitempty"`
+	Owner   int               `json:"owner,omitempty"`
+	Group   int               `json:"group,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	XAttr   map[string][]byte `json:"xattr,omitempty"`
+}
+
+// jsonFormat returns a JSON-encodable description of f under the given name.
+func jsonFormat(ctx context.Context, f *file.File, name, key string) (*listEntry, error) {
+	st := f.Stat()
+	out := &listEntry{
+		Name:    name,
+		Key:     []byte(key),
+		Mode:    st.Mode.String(),
+		Size:    f.Size(),
+		ModTime: st.ModTime,
+		Owner:   st.OwnerID,
+		Group:   st.GroupID,
+	}
+	if listFlags.Human {
+		out.SizeH = config.FormatSize(out.Size)
+	}
+	if st.Mode&fs.ModeSymlink != 0 {
+		target, err := readTarget(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		out.Target = target
+	}
+	f.XAttr().List(func(key, value string) {
+		if out.XAttr == nil {
+			out.XAttr = make(map[string][]byte)
+		}
+		out.XAttr[key] = []byte(value)
+	})
+	return out, nil
+}
+
+// readTarget reads the target of a symbolic link from the contents of f.
+func readTarget(ctx context.Context, f *file.File) (string, error) {
+	data, err := io.ReadAll(f.Cursor(ctx))
+	if err != nil {
+		return "", fmt.Errorf("reading link target: %w", err)
+	}
+	return string(data), nil
+}
+
+// ident formats an owner or group identity, preferring the name if known.
+func ident(name string, id int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprint(id)
+}
+
+// useColor reports whether listings should be colorized for the given value
+// of the -color flag.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "never", "":
+		return false, nil
+	case "always":
+		return true, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return term.IsTerminal(int(os.Stdout.Fd())), nil
+	default:
+		return false, fmt.Errorf("invalid -color %q (want auto, always, or never)", mode)
+	}
+}
+
+// colorName wraps name in terminal color escapes appropriate to mode.
+func colorName(name string, mode fs.FileMode) string {
+	var esc string
+	switch {
+	case mode.IsDir():
+		esc = "\x1b[1;34m" // bold blue
+	case mode&fs.ModeSymlink != 0:
+		esc = "\x1b[1;36m" // bold cyan
+	case mode.IsRegular() && mode&0111 != 0:
+		esc = "\x1b[1;32m" // bold green
+	default:
+		return name
+	}
+	return esc + name + "\x1b[0m"
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffstools/ffs/config"
+)
+
+var mkdirFlags struct {
+	ExistsOK bool
+}
+
+func runMkdir(env *command.Env, args []string) error {
+	if len(args) == 0 {
+		return env.Usagef("missing origin/path")
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return withMutableStore(env, func(s blob.CAS) error {
+		// Paths that share an origin are applied to the same copy of it, so
+		// that each origin is flushed only once at the end.
+		origins := make(map[string]*config.PathInfo)
+		var order []*config.PathInfo
+		for _, arg := range args {
+			base, rest := config.SplitPath(arg)
+			if rest == "" {
+				return fmt.Errorf("missing path %q", arg)
+			}
+			of, ok := origins[base]
+			if !ok {
+				var err error
+				of, err = config.OpenPath(cfg.Context, s, base) // N.B. No path; see below
+				if err != nil {
+					return err
+				}
+				origins[base] = of
+				order = append(order, of)
+			}
+
+			if old, err := fpath.Open(cfg.Context, of.Base, rest); err == nil {
+				if mkdirFlags.ExistsOK && old.Stat().Mode.IsDir() {
+					continue
+				}
+				return fmt.Errorf("path %q already exists", arg)
+			} else if !errors.Is(err, file.ErrChildNotFound) {
+				return err
+			}
+
+			now := time.Now()
+			setDir := func(st *file.Stat) {
+				if st.Mode == 0 {
+					st.Mode = fs.ModeDir | 0755
+				}
+				st.ModTime = now
+			}
+			if _, err := fpath.Set(cfg.Context, of.Base, rest, &fpath.SetOptions{
+				Create:  true,
+				SetStat: setDir,
+				File: of.Base.New(&file.NewOptions{
+					Stat: &file.Stat{Mode: fs.ModeDir | 0755, ModTime: now},
+				}),
+			}); err != nil {
+				return err
+			}
+		}
+		for _, of := range order {
+			key, err := of.Flush(cfg.Context)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%x\n", key)
+		}
+		return nil
+	})
+}
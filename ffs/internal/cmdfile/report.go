@@ -0,0 +1,252 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"container/heap"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffstools/ffs/config"
+)
+
+var reportFlags struct {
+	N     int
+	Since string
+	JSON  bool
+}
+
+func runMtimeReport(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return env.Usagef("got %d arguments, wanted origin/path", len(args))
+	} else if reportFlags.N <= 0 {
+		return env.Usagef("the -n value must be positive")
+	}
+	var since time.Time
+	if reportFlags.Since != "" {
+		var err error
+		since, err = parseTime(reportFlags.Since)
+		if err != nil {
+			return env.Usagef("invalid -since: %v", err)
+		}
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		of, err := config.OpenPath(cfg.Context, s, args[0])
+		if err != nil {
+			return err
+		}
+
+		// Keep the newest n files seen so far in a min-heap by mtime, so that
+		// the oldest of the candidates is always at the top.
+		var top mtimeHeap
+		if err := fpath.Walk(cfg.Context, of.File, func(e fpath.Entry) error {
+			if e.Err != nil {
+				return e.Err
+			}
+			st := e.File.Stat()
+			if st.Mode.IsDir() || st.ModTime.Before(since) {
+				return nil
+			}
+			if len(top) < reportFlags.N {
+				heap.Push(&top, mtimeEntry{path: e.Path, mtime: st.ModTime})
+			} else if st.ModTime.After(top[0].mtime) {
+				top[0] = mtimeEntry{path: e.Path, mtime: st.ModTime}
+				heap.Fix(&top, 0)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Emit the results newest first.
+		out := make([]mtimeEntry, len(top))
+		for i := len(out) - 1; i >= 0; i-- {
+			out[i] = heap.Pop(&top).(mtimeEntry)
+		}
+		for _, e := range out {
+			fmt.Printf("%s\t%s\n", e.mtime.Format(time.RFC3339), e.path)
+		}
+		return nil
+	})
+}
+
+func runStatSummary(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return env.Usagef("got %d arguments, wanted origin/path", len(args))
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		of, err := config.OpenPath(cfg.Context, s, args[0])
+		if err != nil {
+			return err
+		}
+		sum := statSummary{
+			Types: make(map[string]int),
+			Modes: make(map[string]int),
+		}
+		var sizes []int64
+		if err := fpath.Walk(cfg.Context, of.File, func(e fpath.Entry) error {
+			if e.Err != nil {
+				return e.Err
+			}
+			st := e.File.Stat()
+			sum.Total++
+			sum.Types[fileType(st.Mode)]++
+			sum.Modes[fmt.Sprintf("%04o", st.Mode.Perm())]++
+			if st.Persistent() {
+				sum.WithStat++
+			}
+			hasXAttr := false
+			e.File.XAttr().List(func(string, string) { hasXAttr = true })
+			if hasXAttr {
+				sum.WithXAttr++
+			}
+			if st.Mode.IsRegular() {
+				sizes = append(sizes, e.File.Size())
+				sum.TotalBytes += e.File.Size()
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(sizes) != 0 {
+			sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+			sum.MeanBytes = sum.TotalBytes / int64(len(sizes))
+			sum.MedianBytes = sizes[len(sizes)/2]
+		}
+
+		if reportFlags.JSON {
+			fmt.Println(config.ToJSON(sum))
+			return nil
+		}
+		fmt.Printf("files: %d (%d with stat, %d without, %d with xattrs)\n",
+			sum.Total, sum.WithStat, sum.Total-sum.WithStat, sum.WithXAttr)
+		fmt.Println("types:")
+		printCounts(sum.Types)
+		fmt.Printf("regular file sizes: total %d, mean %d, median %d\n",
+			sum.TotalBytes, sum.MeanBytes, sum.MedianBytes)
+		fmt.Println("permissions:")
+		printCounts(sum.Modes)
+		return nil
+	})
+}
+
+type statSummary struct {
+	Total       int            `json:"total"`
+	WithStat    int            `json:"withStat"`
+	WithXAttr   int            `json:"withXAttr"`
+	Types       map[string]int `json:"types"`
+	Modes       map[string]int `json:"modes"`
+	TotalBytes  int64          `json:"totalBytes"`
+	MeanBytes   int64          `json:"meanBytes"`
+	MedianBytes int64          `json:"medianBytes"`
+}
+
+// fileType returns a human-readable label for the type of mode.
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	default:
+		return "other"
+	}
+}
+
+// printCounts prints the entries of m in order by key.
+func printCounts(m map[string]int) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("  %-10s %d\n", key, m[key])
+	}
+}
+
+type mtimeEntry struct {
+	path  string
+	mtime time.Time
+}
+
+// mtimeHeap is a min-heap of entries ordered by modification time.
+type mtimeHeap []mtimeEntry
+
+func (h mtimeHeap) Len() int            { return len(h) }
+func (h mtimeHeap) Less(i, j int) bool  { return h[i].mtime.Before(h[j].mtime) }
+func (h mtimeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mtimeHeap) Push(x interface{}) { *h = append(*h, x.(mtimeEntry)) }
+
+func (h *mtimeHeap) Pop() interface{} {
+	old := *h
+	n := len(old) - 1
+	out := old[n]
+	*h = old[:n]
+	return out
+}
+
+// parseTime parses a timestamp given as "now", "@<seconds>" since the Unix
+// epoch, an age relative to now such as "7d" or "36h", or in RFC3339 format.
+func parseTime(s string) (time.Time, error) {
+	if s == "now" {
+		return time.Now(), nil
+	} else if strings.HasPrefix(s, "@") {
+		sec, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid seconds: %w", err)
+		}
+		return time.Unix(sec, 0), nil
+	} else if d, ok := parseAge(s); ok {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseAge parses a relative age, either a number of days ("7d") or a Go
+// duration ("36h"), and reports whether s had that form.
+func parseAge(s string) (time.Duration, bool) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
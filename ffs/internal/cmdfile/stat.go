@@ -0,0 +1,119 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"fmt"
+	"io/fs"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/file/wiretype"
+	"github.com/creachadair/ffstools/ffs/config"
+)
+
+// statEntry is the JSON encoding of the metadata for a single file.
+type statEntry struct {
+	Key      []byte         `json:"key"`
+	Type     string         `json:"type"`
+	Mode     string         `json:"mode"`
+	Perm     string         `json:"perm"`
+	Size     int64          `json:"size"`
+	Blocks   int            `json:"blocks"`
+	Owner    statID         `json:"owner"`
+	Group    statID         `json:"group"`
+	ModTime  time.Time      `json:"modTime"`
+	Persist  bool           `json:"persistStat"`
+	Children int            `json:"children"`
+	XAttr    map[string]int `json:"xattr,omitempty"` // name → value size
+	Target   string         `json:"target,omitempty"`
+}
+
+// statID is the JSON encoding of an owner or group identity.
+type statID struct {
+	ID   int    `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+func runStat(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return env.Usagef("got %d arguments, wanted origin/path", len(args))
+	}
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		of, err := config.OpenPath(cfg.Context, s, args[0])
+		if err != nil {
+			return err
+		}
+		f := of.File
+		st := f.Stat()
+		out := &statEntry{
+			Key:      []byte(of.FileKey),
+			Type:     fileType(st.Mode),
+			Mode:     st.Mode.String(),
+			Perm:     fmt.Sprintf("%04o", unixMode(st.Mode)),
+			Size:     f.Size(),
+			Blocks:   countBlocks(f),
+			Owner:    statID{ID: st.OwnerID, Name: st.OwnerName},
+			Group:    statID{ID: st.GroupID, Name: st.GroupName},
+			ModTime:  st.ModTime,
+			Persist:  st.Persistent(),
+			Children: f.Child().Len(),
+		}
+		if out.Owner.Name == "" {
+			if u, err := user.LookupId(strconv.Itoa(st.OwnerID)); err == nil {
+				out.Owner.Name = u.Username
+			}
+		}
+		if out.Group.Name == "" {
+			if g, err := user.LookupGroupId(strconv.Itoa(st.GroupID)); err == nil {
+				out.Group.Name = g.Name
+			}
+		}
+		f.XAttr().List(func(key, value string) {
+			if out.XAttr == nil {
+				out.XAttr = make(map[string]int)
+			}
+			out.XAttr[key] = len(value)
+		})
+		if st.Mode&fs.ModeSymlink != 0 {
+			out.Target, err = readTarget(cfg.Context, f)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Println(config.ToJSON(out))
+		return nil
+	})
+}
+
+// countBlocks reports the number of data blocks referenced by f.
+func countBlocks(f *file.File) int {
+	idx := file.Encode(f).Value.(*wiretype.Object_Node).Node.GetIndex()
+	if idx == nil {
+		return 0
+	} else if idx.GetSingle() != nil {
+		return 1
+	}
+	var n int
+	for _, ext := range idx.GetExtents() {
+		n += len(ext.GetBlocks())
+	}
+	return n
+}
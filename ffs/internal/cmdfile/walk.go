@@ -0,0 +1,212 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdfile
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/fpath"
+	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/taskgroup"
+)
+
+var walkFlags struct {
+	Exec  bool
+	Type  string
+	Name  string
+	Since string
+	Until string
+	Limit int
+	Fatal bool
+}
+
+// walkFilter holds the parsed selection criteria for the walk command.
+type walkFilter struct {
+	since, until time.Time
+}
+
+func runWalk(env *command.Env, args []string) error {
+	if len(args) == 0 {
+		return env.Usagef("missing required origin/path")
+	} else if walkFlags.Exec && len(args) < 2 {
+		return env.Usagef("missing command for -exec")
+	} else if walkFlags.Limit <= 0 {
+		return env.Usagef("the -j value must be positive")
+	}
+	switch walkFlags.Type {
+	case "", "f", "d", "l":
+	default:
+		return env.Usagef("invalid -type %q (want f, d, or l)", walkFlags.Type)
+	}
+	if _, err := path.Match(walkFlags.Name, ""); err != nil {
+		return env.Usagef("invalid -name pattern: %v", err)
+	}
+	var wf walkFilter
+	if walkFlags.Since != "" {
+		t, err := parseTime(walkFlags.Since)
+		if err != nil {
+			return env.Usagef("invalid -since: %v", err)
+		}
+		wf.since = t
+	}
+	if walkFlags.Until != "" {
+		t, err := parseTime(walkFlags.Until)
+		if err != nil {
+			return env.Usagef("invalid -until: %v", err)
+		}
+		wf.until = t
+	}
+	origins, cmd := args, []string(nil)
+	if walkFlags.Exec {
+		origins, cmd = args[:1], args[1:]
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		ctx, cancel := context.WithCancel(cfg.Context)
+		defer cancel()
+		g, run := taskgroup.New(taskgroup.Trigger(cancel)).Limit(walkFlags.Limit)
+
+		var nfail int64
+		for _, origin := range origins {
+			of, err := config.OpenPath(ctx, s, origin)
+			if err != nil {
+				return err
+			}
+			if err := fpath.Walk(ctx, of.File, func(e fpath.Entry) error {
+				if e.Err != nil {
+					return e.Err
+				} else if err := ctx.Err(); err != nil {
+					return err
+				} else if !wf.match(e) {
+					return nil
+				}
+				name := path.Join(origin, e.Path)
+				if cmd == nil {
+					fmt.Println(name)
+					return nil
+				}
+				run(func() error {
+					err := execFile(ctx, e.File, cmd)
+					if err == nil {
+						return nil
+					} else if walkFlags.Fatal {
+						return fmt.Errorf("%s: %w", name, err)
+					}
+					atomic.AddInt64(&nfail, 1)
+					log.Printf("%s: %v", name, err)
+					return nil
+				})
+				return nil
+			}); err != nil {
+				// If a command failed, report that rather than the cancellation.
+				if gerr := g.Wait(); gerr != nil {
+					return gerr
+				}
+				return err
+			}
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		} else if nfail > 0 {
+			return fmt.Errorf("command failed for %d files", nfail)
+		}
+		return nil
+	})
+}
+
+// match reports whether e satisfies the -type, -name, and time filters.
+func (w walkFilter) match(e fpath.Entry) bool {
+	st := e.File.Stat()
+	if !w.since.IsZero() && st.ModTime.Before(w.since) {
+		return false
+	} else if !w.until.IsZero() && !st.ModTime.Before(w.until) {
+		return false
+	}
+	mode := st.Mode
+	switch walkFlags.Type {
+	case "f":
+		if !mode.IsRegular() {
+			return false
+		}
+	case "d":
+		if !mode.IsDir() {
+			return false
+		}
+	case "l":
+		if mode&fs.ModeSymlink == 0 {
+			return false
+		}
+	}
+	if walkFlags.Name != "" {
+		ok, _ := path.Match(walkFlags.Name, path.Base(e.Path))
+		return ok
+	}
+	return true
+}
+
+// execFile runs the command described by args with the contents of f.  If any
+// argument is exactly "{}", the contents are written to a temporary file and
+// its path is substituted for each such argument; otherwise the contents are
+// piped to the command's stdin.
+func execFile(ctx context.Context, f *file.File, args []string) error {
+	r := bufio.NewReaderSize(f.Cursor(ctx), 1<<20)
+	argv := make([]string, len(args))
+	copy(argv, args)
+
+	var tmpPath string
+	for i, arg := range argv {
+		if arg != "{}" {
+			continue
+		}
+		if tmpPath == "" {
+			tmp, err := os.CreateTemp("", "ffs-walk-*")
+			if err != nil {
+				return err
+			}
+			tmpPath = tmp.Name()
+			defer os.Remove(tmpPath)
+			_, err = io.Copy(tmp, r)
+			cerr := tmp.Close()
+			if err != nil {
+				return err
+			} else if cerr != nil {
+				return cerr
+			}
+		}
+		argv[i] = tmpPath
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if tmpPath == "" {
+		cmd.Stdin = r
+	}
+	return cmd.Run()
+}
@@ -19,20 +19,23 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"os"
 	"sync/atomic"
 	"time"
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/file/root"
-	"github.com/creachadair/ffs/file/wiretype"
 	"github.com/creachadair/ffs/index"
 	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/ffstools/internal/progress"
 	"github.com/creachadair/taskgroup"
 )
 
 var gcFlags struct {
-	Force bool
+	Force      bool
+	DryRun     bool
+	CountBytes bool
 }
 
 var Command = &command.C{
@@ -42,10 +45,25 @@ var Command = &command.C{
 If no roots are defined, an error is reported without making any changes
 unless -force is set. This avoids accidentally deleting everything in a
 store without roots.
+
+With -dry-run, the mark and sweep phases are run to find the blobs that
+would be deleted, and their number and total size are reported, but no
+blobs are deleted.
+
+With -count-bytes, the size of each blob is fetched before it is deleted,
+and the total number of bytes reclaimed is reported. This may be costly
+for some storage backends, so it is off by default.
+
+While roots are scanned, a progress bar is drawn if stderr is a terminal.
+The total it shows is the number of blobs in the store, an upper bound on
+the number reachable. Send SIGUSR1 to print the progress of the current
+phase to stderr.
 `,
 
 	SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
 		fs.BoolVar(&gcFlags.Force, "force", false, "Force collection on empty root list (DANGER)")
+		fs.BoolVar(&gcFlags.DryRun, "dry-run", false, "Report blobs that would be deleted without deleting them")
+		fs.BoolVar(&gcFlags.CountBytes, "count-bytes", false, "Report the number of bytes reclaimed")
 	},
 
 	Run: func(env *command.Env, args []string) error {
@@ -83,6 +101,8 @@ store without roots.
 			var idxs []*index.Index
 			idx := index.New(int(n), &index.Options{FalsePositiveRate: 0.01})
 			fmt.Fprintf(env, "Begin GC of %d blobs, roots=%+q\n", n, keys)
+			prog := progress.NewCounter("gc mark", n)
+			defer progress.Notify(env, prog)()
 
 			// Mark phase: Scan all roots.
 			for i := 0; i < len(keys); i++ {
@@ -95,16 +115,7 @@ store without roots.
 
 				// If this root has a cached index, use that instead of scanning.
 				if rp.IndexKey != "" {
-					var obj wiretype.Object
-					if err := wiretype.Load(cfg.Context, s, rp.IndexKey, &obj); err != nil {
-						return fmt.Errorf("loading index: %w", err)
-					}
-					ridx := obj.GetIndex()
-					if ridx == nil {
-						return fmt.Errorf("no index in %x", rp.IndexKey)
-					}
-
-					rpi, err := index.Decode(ridx)
+					rpi, err := config.LoadIndex(cfg.Context, s, rp.IndexKey)
 					if err != nil {
 						return fmt.Errorf("decoding index for %q: %w", key, err)
 					}
@@ -126,11 +137,15 @@ store without roots.
 					config.PrintableKey(key), rp.FileKey)
 				start := time.Now()
 				var numKeys int
-				if err := rf.Scan(cfg.Context, func(key string, isFile bool) bool {
+				stopBar := progress.Bar(os.Stderr, prog)
+				err = rf.Scan(cfg.Context, func(key string, isFile bool) bool {
 					numKeys++
+					prog.Add(1)
 					idx.Add(key)
 					return true
-				}); err != nil {
+				})
+				stopBar()
+				if err != nil {
 					return fmt.Errorf("scanning %q: %w", key, err)
 				}
 				fmt.Fprintf(env, "Finished scanning %d blobs [%v elapsed]\n",
@@ -143,11 +158,14 @@ store without roots.
 
 			fmt.Fprintf(env, "Begin sweep over %d blobs...\n", n)
 			start := time.Now()
+			prog.Phase("gc sweep", n)
 			var numKeep, numDrop uint32
+			var dropBytes int64
 			g.Go(func() error {
 				defer fmt.Fprintln(env, "*")
 				return s.List(cfg.Context, "", func(key string) error {
 					run(func() error {
+						defer prog.Add(1)
 						for _, idx := range idxs {
 							if idx.Has(key) {
 								atomic.AddUint32(&numKeep, 1)
@@ -158,6 +176,16 @@ store without roots.
 						if v%50 == 0 {
 							fmt.Fprint(env, ".")
 						}
+						if gcFlags.DryRun || gcFlags.CountBytes {
+							size, err := s.Size(ctx, key)
+							if err != nil {
+								return fmt.Errorf("size of %x: %w", key, err)
+							}
+							atomic.AddInt64(&dropBytes, size)
+						}
+						if gcFlags.DryRun {
+							return nil
+						}
 						return s.Delete(ctx, key)
 					})
 					return nil
@@ -167,8 +195,17 @@ store without roots.
 			if err := g.Wait(); err != nil {
 				return fmt.Errorf("sweeping failed: %w", err)
 			}
-			fmt.Fprintf(env, "GC complete: keep %d, drop %d [%v elapsed]\n",
-				numKeep, numDrop, time.Since(start).Truncate(10*time.Millisecond))
+			elapsed := time.Since(start).Truncate(10 * time.Millisecond)
+			if gcFlags.DryRun {
+				fmt.Fprintf(env, "DRY RUN: no objects deleted; would keep %d, drop %d (%s bytes) [%v elapsed]\n",
+					numKeep, numDrop, config.FormatSize(dropBytes), elapsed)
+				return nil
+			} else if gcFlags.CountBytes {
+				fmt.Fprintf(env, "GC complete: keep %d, drop %d, reclaimed %s bytes [%v elapsed]\n",
+					numKeep, numDrop, config.FormatSize(dropBytes), elapsed)
+				return nil
+			}
+			fmt.Fprintf(env, "GC complete: keep %d, drop %d [%v elapsed]\n", numKeep, numDrop, elapsed)
 			return nil
 		})
 	},
@@ -25,6 +25,7 @@ import (
 	"github.com/creachadair/ffs/file/wiretype"
 	"github.com/creachadair/ffs/index"
 	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/ffstools/internal/progress"
 )
 
 var indexFlags struct {
@@ -39,7 +40,14 @@ Update each of the specified roots to include a blob index.
 
 An index is a Bloom filter of the keys reachable from the root.
 If a root already has an index, it is not changed; use -f to force
-a new index to be computed anyway.`,
+a new index to be computed anyway.
+
+Any change to the file key of a root discards its index, so an existing
+index always describes the current tree, and only roots whose trees have
+changed since they were last indexed are rescanned. Because a Bloom filter
+cannot enumerate its keys, a changed tree is always scanned in full.
+
+Send SIGUSR1 to print the progress of the current scan to stderr.`,
 
 	SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
 		fs.BoolVar(&indexFlags.Force, "f", false, "Force reindexing")
@@ -56,6 +64,9 @@ a new index to be computed anyway.`,
 			if err != nil {
 				return err
 			}
+			prog := progress.NewCounter("index", 0)
+			defer progress.Notify(env, prog)()
+
 			for _, key := range keys {
 				rp, err := root.Open(cfg.Context, config.Roots(s), key)
 				if err != nil {
@@ -73,7 +84,9 @@ a new index to be computed anyway.`,
 				fmt.Fprintf(env, "Scanning data reachable from %q (%x)...\n", key, rp.FileKey)
 				idx := index.New(int(n), &index.Options{FalsePositiveRate: 0.01})
 				start := time.Now()
+				prog.Phase(fmt.Sprintf("index %q", key), 0)
 				if err := fp.Scan(cfg.Context, func(key string, isFile bool) bool {
+					prog.Add(1)
 					idx.Add(key)
 					return true
 				}); err != nil {
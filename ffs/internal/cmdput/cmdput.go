@@ -17,29 +17,105 @@ package cmdput
 import (
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/file"
 	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/ffstools/internal/progress"
 	"github.com/creachadair/taskgroup"
 	"github.com/pkg/xattr"
 )
 
-var putFlags struct {
-	NoStat  bool
-	XAttr   bool
-	Verbose bool
+// putOptions are the settings of the put command, populated from its flags.
+type putOptions struct {
+	NoStat       bool
+	XAttr        bool
+	Verbose      bool
+	OwnerMap     string
+	GroupMap     string
+	DefaultOwner string
+	DefaultGroup string
+	ExcludeXAttr string
+	NoEmptyDirs  bool
+	CanonMTime   string
+	Base         string
+	Include      string
+	MaxSize      string
+	MinSize      string
+	Hardlinks    bool
 }
 
+var putFlags putOptions
+
+// hardlinks records hard-linked files for -hardlinks. It is nil if -hardlinks
+// is not set.
+var hardlinks *linkSet
+
+// A linkSet records the first file stored for each inode with multiple links,
+// and the later links whose first file was not yet stored when they were
+// found. Those are attached by resolve once the walk is complete.
+type linkSet struct {
+	first   map[fileID]**file.File
+	pending []pendingLink
+}
+
+type pendingLink struct {
+	dir   *file.File
+	name  string
+	first **file.File
+}
+
+// resolve attaches each pending link to its directory.
+func (ls *linkSet) resolve() {
+	if ls == nil {
+		return
+	}
+	for _, p := range ls.pending {
+		// Adding a child updates the modification time of dir, so restore it.
+		st := p.dir.Stat()
+		p.dir.Child().Set(p.name, *p.first)
+		st.Update()
+	}
+	ls.pending = nil
+}
+
+// Size limits for regular files, populated from the -max-size and -min-size
+// flags. A maxSize of zero means no limit.
+var maxSize, minSize int64
+
+// canonMTime, if non-zero, replaces the modification time of every file and
+// directory stored. It is populated from the -canonical-mtime flag.
+var canonMTime time.Time
+
+// defaultExcludeXAttr is the default set of extended attribute patterns
+// excluded from capture with -xattr. These are host-specific attributes that
+// are not meaningful when restored elsewhere.
+const defaultExcludeXAttr = "com.apple.quarantine,security.selinux"
+
+// putProgress counts the files and directories stored.
+var putProgress = progress.NewCounter("put", 0)
+
+// putStats counts what was stored, for the summary.
+var putStats struct {
+	files, bytes, dirs, reused, links int64 // accessed atomically
+}
+
+// Owner and group ID mappings, populated from the flags.
+var ownerMap, groupMap *idMap
+
 var Command = &command.C{
 	Name:  "put",
 	Usage: "<path> ...",
@@ -48,15 +124,76 @@ var Command = &command.C{
 Recursively copy each specified path from the local filesystem to the
 store, and print the storage key. By default, file and directory stat
 info are recorded; use -nostat to disable this. Use -xattr to capture
-extended attributes.
+extended attributes. By default, some host-specific attributes are
+not captured; use -exclude-xattr to change the exclusion list. Each
+exclusion is a glob pattern or name prefix; an empty list captures all.
 
 Symbolic links are captured, but devices, sockets, FIFO, and other
-special files are skipped.`,
+special files are skipped. With -include, only files and symlinks whose
+names match at least one of the given comma-separated glob patterns are
+captured. Directories are always descended, so that matching files
+beneath them are found; combine with -exclude-empty-dirs to omit the
+directories that contain no matches.
+
+Use -max-size and -min-size to skip regular files larger or smaller than
+the given size. A size is a number of bytes, optionally fractional and
+with a suffix K, M, or G for units of 1024, 1024², or 1024³ bytes, for
+example 512K or 1.5G. These limits do not apply to directories
+or symlinks. With -exclude-empty-dirs, directories that
+contain nothing after skipping (including directories that contained only
+empty directories) are omitted; the top-level path is always stored.
+
+Use -owner-map and -group-map to remap the owner and group IDs recorded
+in the stat info. Each accepts either the path of a file with one rule
+per line, or a comma-separated list of rules. A rule has the form
+"old:new", where old and new are numeric IDs or names. IDs not matched
+by any rule are kept as-is unless -default-owner or -default-group is
+set, in which case that value is used instead.
+
+By default the modification times of files and directories are recorded,
+so storing identical content at different times may produce different
+file keys. Use -canonical-mtime to record the same fixed time for every
+file and directory instead, so that storing identical content (with the
+same permissions and ownership) always produces identical keys. The value
+is "epoch" for the Unix epoch, "@<seconds>" since the epoch, or an RFC3339
+timestamp.
+
+With -base, each path is compared against a previously stored tree,
+given as a file or root path. A regular file whose path, size, and
+modification time match a file in the base tree is not read again;
+instead the stored file is reused, with its stat info and extended
+attributes updated from the local file. This makes storing a tree that
+has changed little since the base much faster. The -base flag cannot
+be combined with -nostat or -canonical-mtime, since the base tree must
+record the actual modification times.
+
+With -hardlinks, a regular file with multiple hard links is stored once:
+each later link to the same file is attached to the file stored for the
+first, so they share a single node in the stored tree. This requires the
+platform to report device and inode numbers; where it does not (e.g., on
+Windows), each link is stored as a separate file, as without the flag.
+
+When stderr is a terminal, a progress bar is drawn while storing, unless
+-v is set. When done, a summary of the files, bytes, and directories
+stored is printed.`,
 
 	SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
 		fs.BoolVar(&putFlags.NoStat, "nostat", false, "Omit file and directory stat")
 		fs.BoolVar(&putFlags.XAttr, "xattr", false, "Capture extended attributes")
 		fs.BoolVar(&putFlags.Verbose, "v", false, "Enable verbose logging")
+		fs.BoolVar(&putFlags.NoEmptyDirs, "exclude-empty-dirs", false, "Omit directories with no files or subdirectories")
+		fs.StringVar(&putFlags.ExcludeXAttr, "exclude-xattr", defaultExcludeXAttr,
+			"Comma-separated extended attribute patterns to skip with -xattr")
+		fs.StringVar(&putFlags.OwnerMap, "owner-map", "", "Owner ID mapping rules or file")
+		fs.StringVar(&putFlags.GroupMap, "group-map", "", "Group ID mapping rules or file")
+		fs.StringVar(&putFlags.DefaultOwner, "default-owner", "", "Owner for IDs not matched by -owner-map")
+		fs.StringVar(&putFlags.DefaultGroup, "default-group", "", "Group for IDs not matched by -group-map")
+		fs.StringVar(&putFlags.Include, "include", "", "Comma-separated glob patterns of file names to capture")
+		fs.StringVar(&putFlags.MaxSize, "max-size", "", "Skip regular files larger than this size")
+		fs.StringVar(&putFlags.MinSize, "min-size", "", "Skip regular files smaller than this size")
+		fs.BoolVar(&putFlags.Hardlinks, "hardlinks", false, "Store hard-linked files once")
+		fs.StringVar(&putFlags.Base, "base", "", "Reuse unchanged files from this stored tree")
+		fs.StringVar(&putFlags.CanonMTime, "canonical-mtime", "", "Record this modification time for all files")
 	},
 	Run: runPut,
 }
@@ -65,27 +202,92 @@ func runPut(env *command.Env, args []string) error {
 	if len(args) == 0 {
 		return env.Usagef("missing required path")
 	}
+	for _, pat := range strings.Split(putFlags.ExcludeXAttr, ",") {
+		if _, err := path.Match(pat, ""); err != nil {
+			return env.Usagef("invalid -exclude-xattr pattern %q: %v", pat, err)
+		}
+	}
+	for _, pat := range strings.Split(putFlags.Include, ",") {
+		if _, err := path.Match(pat, ""); err != nil {
+			return env.Usagef("invalid -include pattern %q: %v", pat, err)
+		}
+	}
+	if putFlags.Base != "" && (putFlags.NoStat || putFlags.CanonMTime != "") {
+		return env.Usagef("-base cannot be used with -nostat or -canonical-mtime")
+	}
+	var err error
+	if maxSize, err = config.ParseSize(putFlags.MaxSize); err != nil {
+		return env.Usagef("invalid -max-size: %v", err)
+	} else if minSize, err = config.ParseSize(putFlags.MinSize); err != nil {
+		return env.Usagef("invalid -min-size: %v", err)
+	} else if maxSize > 0 && minSize > maxSize {
+		return env.Usagef("-min-size is larger than -max-size")
+	}
+	canonMTime, err = parseCanonMTime(putFlags.CanonMTime)
+	if err != nil {
+		return env.Usagef("invalid -canonical-mtime: %v", err)
+	}
+	ownerMap, err = parseIDMap(putFlags.OwnerMap, putFlags.DefaultOwner, lookupUser)
+	if err != nil {
+		return fmt.Errorf("owner map: %w", err)
+	}
+	groupMap, err = parseIDMap(putFlags.GroupMap, putFlags.DefaultGroup, lookupGroup)
+	if err != nil {
+		return fmt.Errorf("group map: %w", err)
+	}
 
 	cfg := env.Config.(*config.Settings)
 	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		putProgress.Phase("put", int64(len(args)))
+		putStats.files, putStats.bytes, putStats.dirs, putStats.reused, putStats.links = 0, 0, 0, 0, 0
+		hardlinks = nil
+		if putFlags.Hardlinks {
+			hardlinks = &linkSet{first: make(map[fileID]**file.File)}
+		}
+		defer progress.Notify(env, putProgress)()
+		stopBar := func() {}
+		if !putFlags.Verbose {
+			stopBar = progress.Bar(os.Stderr, putProgress)
+		}
+		start := time.Now()
+
+		var base *file.File
+		if putFlags.Base != "" {
+			of, err := config.OpenPath(cfg.Context, s, putFlags.Base)
+			if err != nil {
+				stopBar()
+				return fmt.Errorf("opening base: %w", err)
+			}
+			base = of.File
+		}
+
 		keys := make([]string, len(args))
 		for i, path := range args {
 			if putFlags.Verbose {
 				log.Printf("put %q", path)
 			}
-			f, err := putDir(cfg.Context, s, path)
-			if err != nil {
-				return err
+			f, err := putDir(cfg.Context, s, path, base)
+			if err == nil {
+				hardlinks.resolve()
+				keys[i], err = f.Flush(cfg.Context)
 			}
-			key, err := f.Flush(cfg.Context)
 			if err != nil {
+				stopBar()
 				return err
 			}
-			keys[i] = key
 			if putFlags.Verbose {
-				log.Printf("finished %q (%x)", path, key)
+				log.Printf("finished %q (%x)", path, keys[i])
 			}
 		}
+		stopBar()
+		msg := fmt.Sprintf("Stored %d files (%d bytes), %d directories", putStats.files, putStats.bytes, putStats.dirs)
+		if base != nil {
+			msg += fmt.Sprintf(", reused %d unchanged files", putStats.reused)
+		}
+		if putStats.links != 0 {
+			msg += fmt.Sprintf(", %d hard links", putStats.links)
+		}
+		fmt.Fprintf(env, "%s [%v elapsed]\n", msg, time.Since(start).Truncate(10*time.Millisecond))
 		for _, key := range keys {
 			fmt.Printf("%x\n", key)
 		}
@@ -93,9 +295,27 @@ func runPut(env *command.Env, args []string) error {
 	})
 }
 
+// Put writes the file, directory, or symlink at path into s, and returns the
+// storage key of the resulting file. Put uses the default values of the put
+// command's flags, regardless of whether or how the put command has run.
+func Put(ctx context.Context, s blob.CAS, path string) (string, error) {
+	putFlags = putOptions{ExcludeXAttr: defaultExcludeXAttr}
+	maxSize, minSize = 0, 0
+	canonMTime = time.Time{}
+	ownerMap, groupMap = nil, nil
+	hardlinks = nil
+	f, err := putDir(ctx, s, path, nil)
+	if err != nil {
+		return "", err
+	}
+	hardlinks.resolve()
+	return f.Flush(ctx)
+}
+
 // putFile puts a single file or symlink into the store.
 // The caller is responsible for closing in after putFile returns.
 func putFile(ctx context.Context, s blob.CAS, path string, fi fs.FileInfo) (*file.File, error) {
+	defer putProgress.Add(1)
 	f := file.New(s, &file.NewOptions{
 		Name: fi.Name(),
 		Stat: fileInfoToStat(fi),
@@ -117,6 +337,7 @@ func putFile(ctx context.Context, s blob.CAS, path string, fi fs.FileInfo) (*fil
 		if err := f.SetData(ctx, r); err != nil {
 			return nil, fmt.Errorf("copying data: %w", err)
 		}
+		atomic.AddInt64(&putStats.bytes, f.Size())
 	} else if fi.Mode()&fs.ModeSymlink != 0 {
 		// Write symbolic link target as file content.
 		tgt, err := os.Readlink(path)
@@ -126,28 +347,79 @@ func putFile(ctx context.Context, s blob.CAS, path string, fi fs.FileInfo) (*fil
 			return nil, err
 		}
 	}
+	atomic.AddInt64(&putStats.files, 1)
 	return f, nil
 }
 
+// reuseFile reports whether old, the corresponding file from the -base tree,
+// can be reused for the local file at path. If so, the stat info and extended
+// attributes of old are updated from the local file.
+func reuseFile(old *file.File, path string, fi fs.FileInfo) (bool, error) {
+	if old == nil || !fi.Mode().IsRegular() {
+		return false, nil
+	}
+	st := old.Stat()
+	if !st.Persistent() || !st.Mode.IsRegular() || old.Size() != fi.Size() || !st.ModTime.Equal(fi.ModTime()) {
+		return false, nil
+	}
+	nst := fileInfoToStat(fi)
+	st.Edit(func(st *file.Stat) {
+		st.Mode, st.ModTime = nst.Mode, nst.ModTime
+		st.OwnerID, st.OwnerName = nst.OwnerID, ""
+		st.GroupID, st.GroupName = nst.GroupID, ""
+	}).Update()
+	old.XAttr().Clear()
+	if err := addExtAttrs(path, old); err != nil {
+		return false, err
+	}
+	putProgress.Add(1)
+	atomic.AddInt64(&putStats.files, 1)
+	atomic.AddInt64(&putStats.reused, 1)
+	return true, nil
+}
+
+// openBase returns the child of base with the given name, or nil if base is
+// nil or has no such child.
+func openBase(ctx context.Context, base *file.File, name string) (*file.File, error) {
+	if base == nil {
+		return nil, nil
+	}
+	kid, err := base.Open(ctx, name)
+	if errors.Is(err, file.ErrChildNotFound) {
+		return nil, nil
+	}
+	return kid, err
+}
+
 // putDir puts a single file, directory, or symlink into the store.
 // If path names a plain file or symlin, it calls putFile.
-func putDir(ctx context.Context, s blob.CAS, path string) (*file.File, error) {
+// If base != nil, it is the corresponding file in the -base tree.
+func putDir(ctx context.Context, s blob.CAS, path string, base *file.File) (*file.File, error) {
 	fi, err := os.Lstat(path)
 	if err != nil {
 		return nil, err
 	}
 	if !fi.IsDir() {
 		// Non-directory files, symlinks, etc.
+		if ok, err := reuseFile(base, path, fi); err != nil {
+			return nil, err
+		} else if ok {
+			return base, nil
+		}
 		return putFile(ctx, s, path, fi)
 	}
+	if base != nil && !base.Stat().Mode.IsDir() {
+		base = nil
+	}
 	if putFlags.Verbose {
 		log.Printf("enter %q", path)
 	}
 
 	// Directory
+	dstat := fileInfoToStat(fi)
 	d := file.New(s, &file.NewOptions{
 		Name: fi.Name(),
-		Stat: fileInfoToStat(fi),
+		Stat: dstat,
 	})
 
 	// Extended attributes (if -xattr is set)
@@ -166,33 +438,84 @@ func putDir(ctx context.Context, s blob.CAS, path string) (*file.File, error) {
 		name string
 		fi   fs.FileInfo
 		kid  *file.File
+		link **file.File // for -hardlinks, the first file with the same inode
 	}
 
 	// Partition the contents of the directory into plain files and directories.
-	var files, dirs []*entry
+	// With -hardlinks, later links to an inode already seen are set aside.
+	var files, dirs, linked []*entry
 	for _, elt := range elts {
 		sub := filepath.Join(path, elt.Name())
 		if elt.IsDir() {
 			dirs = append(dirs, &entry{sub: sub, name: elt.Name()})
 		} else if t := elt.Type(); t != 0 && (t&fs.ModeSymlink == 0) {
 			continue // e.g., socket, pipe, device, fifo, etc.
+		} else if !includeFile(elt.Name()) {
+			continue
 		} else if fi, err = elt.Info(); err != nil {
 			return nil, err
+		} else if fi.Mode().IsRegular() && !sizeOK(fi.Size()) {
+			if putFlags.Verbose {
+				log.Printf("skip %q (%d bytes)", sub, fi.Size())
+			}
 		} else {
-			files = append(files, &entry{sub: sub, name: elt.Name(), fi: fi})
+			e := &entry{sub: sub, name: elt.Name(), fi: fi}
+			if id, ok := linkID(fi); ok && hardlinks != nil {
+				if first, ok := hardlinks.first[id]; ok {
+					e.link = first
+					linked = append(linked, e)
+					continue
+				}
+				hardlinks.first[id] = &e.kid
+			}
+			files = append(files, e)
 		}
 	}
+	putProgress.AddTotal(int64(len(files) + len(dirs)))
 
 	// Process subdirectories serially. We do this so that the recurrence does
 	// not explode concurrency.
 	for _, e := range dirs {
-		kid, err := putDir(ctx, s, e.sub)
+		old, err := openBase(ctx, base, e.name)
 		if err != nil {
 			return nil, err
 		}
+		kid, err := putDir(ctx, s, e.sub, old)
+		if err != nil {
+			return nil, err
+		} else if putFlags.NoEmptyDirs && kid.Child().Len() == 0 {
+			if putFlags.Verbose {
+				log.Printf("skip empty directory %q", e.sub)
+			}
+			atomic.AddInt64(&putStats.dirs, -1)
+			continue
+		}
 		d.Child().Set(e.name, kid)
 	}
 
+	// Reuse unchanged files from the base tree. This is done serially, since
+	// opening the children of base is not safe for concurrent use.
+	if base != nil {
+		var rest []*entry
+		for _, e := range files {
+			old, err := openBase(ctx, base, e.name)
+			if err != nil {
+				return nil, err
+			} else if ok, err := reuseFile(old, e.sub, e.fi); err != nil {
+				return nil, err
+			} else if ok {
+				if putFlags.Verbose {
+					log.Printf("reuse unchanged %q", e.name)
+				}
+				e.kid = old
+				d.Child().Set(e.name, old)
+				continue
+			}
+			rest = append(rest, e)
+		}
+		files = rest
+	}
+
 	// Process plain files in parallel.
 	if len(files) != 0 {
 		if putFlags.Verbose {
@@ -230,6 +553,31 @@ func putDir(ctx context.Context, s blob.CAS, path string) (*file.File, error) {
 		}
 	}
 
+	// Attach hard links to the files stored for their first links. If the
+	// first link is in a directory not yet complete, its file is not stored
+	// yet, so hold the place with an empty file until it is.
+	for _, e := range linked {
+		if *e.link != nil {
+			if putFlags.Verbose {
+				log.Printf("hard link %q", e.sub)
+			}
+			d.Child().Set(e.name, *e.link)
+		} else {
+			d.Child().Set(e.name, d.New(nil))
+			hardlinks.pending = append(hardlinks.pending, pendingLink{dir: d, name: e.name, first: e.link})
+		}
+		atomic.AddInt64(&putStats.links, 1)
+	}
+
+	putProgress.Add(1)
+	atomic.AddInt64(&putStats.dirs, 1)
+
+	// Adding children updates the modification time of d, so restore it.
+	if dstat != nil {
+		st := d.Stat()
+		st.ModTime = dstat.ModTime
+		st.Update()
+	}
 	return d, nil
 }
 
@@ -243,6 +591,9 @@ func addExtAttrs(path string, f *file.File) error {
 	}
 	xa := f.XAttr()
 	for _, name := range names {
+		if excludeXAttr(name) {
+			continue
+		}
 		data, err := xattr.LGet(path, name)
 		if err != nil {
 			return fmt.Errorf("get xattr %q: %w", name, err)
@@ -252,15 +603,73 @@ func addExtAttrs(path string, f *file.File) error {
 	return nil
 }
 
+// excludeXAttr reports whether the extended attribute name matches any of the
+// patterns in the -exclude-xattr flag, either as a glob or a name prefix.
+func excludeXAttr(name string) bool {
+	for _, pat := range strings.Split(putFlags.ExcludeXAttr, ",") {
+		if pat == "" {
+			continue
+		} else if strings.HasPrefix(name, pat) {
+			return true
+		} else if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// includeFile reports whether a file with the given name should be captured.
+// If -include is set, name must match one of its patterns.
+func includeFile(name string) bool {
+	if putFlags.Include == "" {
+		return true
+	}
+	for _, pat := range strings.Split(putFlags.Include, ",") {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 func fileInfoToStat(fi fs.FileInfo) *file.Stat {
 	if putFlags.NoStat {
 		return nil
 	}
 	owner, group := ownerAndGroup(fi)
+	mtime := fi.ModTime()
+	if !canonMTime.IsZero() {
+		mtime = canonMTime
+	}
 	return &file.Stat{
 		Mode:    fi.Mode(),
-		ModTime: fi.ModTime(),
-		OwnerID: owner,
-		GroupID: group,
+		ModTime: mtime,
+		OwnerID: ownerMap.Map(owner),
+		GroupID: groupMap.Map(group),
+	}
+}
+
+// sizeOK reports whether a regular file of the given size is within the
+// limits set by -max-size and -min-size.
+func sizeOK(size int64) bool {
+	return size >= minSize && (maxSize == 0 || size <= maxSize)
+}
+
+// parseCanonMTime parses the value of the -canonical-mtime flag. An empty
+// string means no canonical time, and is reported as the zero time.
+func parseCanonMTime(s string) (time.Time, error) {
+	switch {
+	case s == "":
+		return time.Time{}, nil
+	case s == "epoch":
+		return time.Unix(0, 0), nil
+	case strings.HasPrefix(s, "@"):
+		secs, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid seconds: %w", err)
+		}
+		return time.Unix(secs, 0), nil
+	default:
+		return time.Parse(time.RFC3339, s)
 	}
 }
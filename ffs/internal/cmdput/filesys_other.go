@@ -14,3 +14,16 @@ func ownerAndGroup(fi fs.FileInfo) (owner, group int) {
 	}
 	return 0, 0
 }
+
+// A fileID identifies a file by its device and inode numbers.
+type fileID struct{ dev, ino uint64 }
+
+// linkID reports the device and inode of fi, if it is a regular file with more
+// than one hard link.
+func linkID(fi fs.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || !fi.Mode().IsRegular() || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,122 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdput
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// An idMap remaps numeric owner or group IDs.
+type idMap struct {
+	m      map[int]int
+	def    int  // the ID to use for unmapped IDs, if hasDef
+	hasDef bool // whether def is set
+}
+
+// Map returns the ID that id maps to. If id is not mapped and no default is
+// set, id is returned unchanged.
+func (m *idMap) Map(id int) int {
+	if m == nil {
+		return id
+	} else if v, ok := m.m[id]; ok {
+		return v
+	} else if m.hasDef {
+		return m.def
+	}
+	return id
+}
+
+// parseIDMap parses a set of ID mapping rules and a default ID.
+//
+// The rules may be given inline as a comma-separated list, or as the path of
+// a file containing one rule per line, in which blank lines and lines
+// beginning with "#" are ignored. Each rule has the form "old:new", where old
+// and new are numeric IDs or names resolved by lookup.  If def is not empty,
+// it gives an ID (or name) to assign to IDs not matched by any rule.
+func parseIDMap(rules, def string, lookup func(string) (int, error)) (*idMap, error) {
+	if rules == "" && def == "" {
+		return nil, nil
+	}
+	out := &idMap{m: make(map[int]int)}
+	if def != "" {
+		id, err := lookup(def)
+		if err != nil {
+			return nil, err
+		}
+		out.def, out.hasDef = id, true
+	}
+	if rules == "" {
+		return out, nil
+	}
+
+	var lines []string
+	if data, err := os.ReadFile(rules); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+	} else if os.IsNotExist(err) {
+		lines = strings.Split(rules, ",")
+	} else {
+		return nil, err
+	}
+
+	for _, rule := range lines {
+		lhs, rhs, ok := strings.Cut(strings.TrimSpace(rule), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping rule %q", rule)
+		}
+		from, err := lookup(lhs)
+		if err != nil {
+			return nil, err
+		}
+		to, err := lookup(rhs)
+		if err != nil {
+			return nil, err
+		}
+		out.m[from] = to
+	}
+	return out, nil
+}
+
+// lookupUser resolves a numeric user ID or a user name to an ID.
+func lookupUser(s string) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGroup resolves a numeric group ID or a group name to an ID.
+func lookupGroup(s string) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -16,10 +16,16 @@ package cmdroot
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"text/tabwriter"
 
 	"github.com/creachadair/command"
 	"github.com/creachadair/ffs/blob"
@@ -27,6 +33,7 @@ import (
 	"github.com/creachadair/ffs/file/root"
 	"github.com/creachadair/ffs/file/wiretype"
 	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/taskgroup"
 )
 
 var Command = &command.C{
@@ -42,19 +49,64 @@ var Command = &command.C{
 			Run: runShow,
 		},
 		{
-			Name: "list",
-			Help: "List the root keys known in the store.",
+			Name:  "describe",
+			Usage: "<root-key>",
+			Help: `Print a detailed description of a filesystem root.
+
+Print the description, file key, and index key of the root, statistics
+for its index if it has one, and the number of top-level children of its
+file. Use -size to also scan the tree and report the number and total
+size of all reachable blobs; this may be expensive for large trees.
+
+If the root has no index, this is reported explicitly; with -json, the
+"index" field is null.`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.BoolVar(&describeFlags.JSON, "json", false, "Write the description as JSON")
+				fs.BoolVar(&describeFlags.Size, "size", false, "Compute the size of the reachable tree")
+			},
+			Run: runDescribe,
+		},
+		{
+			Name:  "list",
+			Usage: "[<glob>...]",
+			Help: `List the root keys known in the store.
+
+If glob patterns are given, only root keys matching at least one of them
+are listed. With -desc-match, only roots whose description matches the
+given regular expression are listed. This requires reading each root.
+With -indexed or -no-index, only roots that do or do not have a cached
+index are listed. When several filters are given, a root must satisfy
+all of them to be listed.
 
+By default roots are listed in the order of the store. Use -sort to sort
+them by "name", by description ("desc"), or by the number of keys in
+their index ("size"), largest first. Sorting by size loads the index of
+each root; roots without an index, or whose index cannot be loaded, are
+treated as having size zero.`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.StringVar(&listFlags.DescMatch, "desc-match", "", "List roots whose description matches this regexp")
+				fs.StringVar(&listFlags.Sort, "sort", "", "Sort roots by name, desc, or size")
+				fs.BoolVar(&listFlags.Indexed, "indexed", false, "List only roots that have an index")
+				fs.BoolVar(&listFlags.NoIndex, "no-index", false, "List only roots that do not have an index")
+			},
 			Run: runList,
 		},
 		{
 			Name:  "create",
 			Usage: "<name> <description>...",
-			Help:  "Create a new (empty) root pointer.",
+			Help: `Create a new root pointer.
+
+By default the root refers to a new empty directory. Use -key to set the
+initial file key instead. With -check, every blob reachable from the file
+key is verified to be present in the store before the root is created,
+and the root is not created if any are missing.`,
 
 			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
 				fs.BoolVar(&createFlags.Replace, "replace", false, "Replace an existing root name")
 				fs.StringVar(&createFlags.FileKey, "key", "", "Initial file key")
+				fs.BoolVar(&createFlags.Check, "check", false, "Verify that the tree under -key is complete")
 			},
 			Run: runCreate,
 		},
@@ -69,12 +121,20 @@ var Command = &command.C{
 			Run: runCopy,
 		},
 		{
-			Name:  "rename",
-			Usage: "<source-name> <target-name>",
-			Help:  "Rename a root pointer (equivalent to copy + remove).",
+			Name: "rename",
+			Usage: `<source-name> <target-name>
+-prefix <old-prefix> <new-prefix>`,
+			Help: `Rename a root pointer (equivalent to copy + remove).
+
+With -prefix, rename every root whose name begins with the old prefix,
+replacing that prefix with the new one. All the new names are checked
+before any root is renamed, and nothing is changed if any of them exists
+(unless -replace is set) or is also one of the names being renamed.
+Each renaming is printed as it is done.`,
 
 			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
 				fs.BoolVar(&copyFlags.Replace, "replace", false, "Replace an existing target root name")
+				fs.BoolVar(&copyFlags.Prefix, "prefix", false, "Rename all roots with the given name prefix")
 			},
 			Run: runCopy,
 		},
@@ -95,10 +155,114 @@ var Command = &command.C{
 		{
 			Name:  "set-file",
 			Usage: "<name> <file-key>",
-			Help:  "Edit the file key of the given root",
+			Help: `Edit the file key of the given root
+
+With -keep-history N, the previous version of the root is kept as a root
+named <name>~1, the one before it as <name>~2, and so on, keeping at most
+N prior versions. Use "root history" to list them, and "set-file" to roll
+back to one of them.`,
 
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.IntVar(&editFileFlags.KeepHistory, "keep-history", 0, "Keep up to this many prior versions of the root")
+			},
 			Run: runEditFile,
 		},
+		{
+			Name:  "history",
+			Usage: "<name>",
+			Help: `List the prior versions of a root kept by set-file -keep-history.
+
+For each prior version, print its root name, its file key, and the
+modification time of its file, newest first.`,
+
+			Run: runHistory,
+		},
+		{
+			Name:  "overlap",
+			Usage: "<root-key> <root-key>",
+			Help: `Report the storage shared between two roots.
+
+Compute the sets of blobs reachable from each root, and report the number
+and total size of the blobs unique to each root and shared by both.
+A cached index cannot enumerate its keys, so both trees are scanned.`,
+
+			Run: runOverlap,
+		},
+		{
+			Name:  "verify-index",
+			Usage: "<root-key> ...",
+			Help: `Check the cached index of each root against its tree.
+
+Scan the blobs reachable from the file of each root, and report any
+that its index claims are absent. Such keys would be lost by a garbage
+collection that relies on the index, so the index fails verification.
+Also report an estimate of the number of keys in the index that are no
+longer reachable, which are retained by a collection but harmless.
+
+Roots without an index are reported and skipped. The command fails if
+any index fails verification.`,
+
+			Run: runVerifyIndex,
+		},
+		{
+			Name:  "merge",
+			Usage: "<root-key> <root-key> <new-name>",
+			Help: `Merge the trees of two roots into a new root.
+
+The new root refers to a tree containing the union of the paths in both
+trees. Directories present in both trees are merged recursively. When a
+non-directory path exists in both trees with different contents, the
+version from the root chosen by -prefer is kept.
+
+When a path is a directory in one tree and a non-directory in the other,
+-types chooses the outcome: "prefer" keeps the version selected by
+-prefer, "dir" keeps the directory, and "error" stops the merge.
+
+The source roots are not modified. The number of collisions resolved
+is printed when the merge is complete.`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.StringVar(&mergeFlags.Prefer, "prefer", "b", `Which root wins a collision ("a" or "b")`)
+				fs.StringVar(&mergeFlags.Types, "types", "prefer", `Policy for directory-vs-file collisions ("prefer", "dir", "error")`)
+				fs.BoolVar(&mergeFlags.Replace, "replace", false, "Replace an existing target root name")
+			},
+			Run: runMerge,
+		},
+		{
+			Name:  "export",
+			Usage: "[<glob>...]",
+			Help: `Export root pointers as a JSON or YAML document.
+
+Write the name, description, file key, and index key of each root in the
+store to stdout, or to the file named by -o. If glob patterns are given,
+only roots whose keys match at least one of them are exported. Storage
+keys are encoded as base64. The output can be read by "root import".`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.StringVar(&exportFlags.Output, "o", "-", `Write output to this file ("-" for stdout)`)
+				fs.BoolVar(&exportFlags.YAML, "yaml", false, "Write YAML instead of JSON")
+			},
+			Run: runExport,
+		},
+		{
+			Name:  "import",
+			Usage: "<file>",
+			Help: `Import root pointers from a JSON or YAML document.
+
+Read a document written by "root export" from the named file ("-" for
+stdin), and create each of the roots it describes. As with "create", it
+is an error if a root already exists unless -replace is set.
+
+Before a root is created, its file key is checked to exist in the store.
+Roots whose file is missing are skipped with a warning, unless -force is
+set. The name of each root created is printed to stdout.`,
+
+			SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
+				fs.BoolVar(&importFlags.Replace, "replace", false, "Replace existing root names")
+				fs.BoolVar(&importFlags.Force, "force", false, "Create roots even if their file is missing")
+			},
+			Run: runImport,
+		},
 	},
 }
 
@@ -130,22 +294,346 @@ func runShow(env *command.Env, keys []string) error {
 	})
 }
 
+var describeFlags struct {
+	JSON bool
+	Size bool
+}
+
+type rootDescription struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	FileKey     []byte      `json:"fileKey"`
+	IndexKey    []byte      `json:"indexKey,omitempty"`
+	Index       *indexStats `json:"index"` // nil if the root has no index
+	NumChildren int         `json:"numChildren"`
+	NumBlobs    int64       `json:"numBlobs,omitempty"`
+	TotalBytes  int64       `json:"totalBytes,omitempty"`
+}
+
+type indexStats struct {
+	NumKeys    int     `json:"numKeys"`
+	FilterBits int     `json:"filterBits"`
+	NumHashes  int     `json:"numHashes"`
+	FPR        float64 `json:"falsePositiveRate"`
+}
+
+func runDescribe(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return env.Usagef("got %d arguments, wanted <root-key>", len(args))
+	}
+	key := strings.TrimPrefix(args[0], "@")
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		rp, err := root.Open(cfg.Context, config.Roots(s), key)
+		if err != nil {
+			return err
+		}
+		rf, err := rp.File(cfg.Context, s)
+		if err != nil {
+			return err
+		}
+		desc := rootDescription{
+			Name:        key,
+			Description: rp.Description,
+			FileKey:     []byte(rp.FileKey),
+			IndexKey:    []byte(rp.IndexKey),
+			NumChildren: rf.Child().Len(),
+		}
+		if rp.IndexKey != "" {
+			idx, err := config.LoadIndex(cfg.Context, s, rp.IndexKey)
+			if err != nil {
+				return err
+			}
+			st := idx.Stats()
+			desc.Index = &indexStats{
+				NumKeys:    st.NumKeys,
+				FilterBits: st.FilterBits,
+				NumHashes:  st.NumHashes,
+				FPR:        config.IndexFPR(st),
+			}
+		}
+		if describeFlags.Size {
+			var keys []string
+			if err := rf.Scan(cfg.Context, func(key string, isFile bool) bool {
+				keys = append(keys, key)
+				return true
+			}); err != nil {
+				return fmt.Errorf("scanning %q: %w", key, err)
+			}
+			for _, key := range keys {
+				n, err := s.Size(cfg.Context, key)
+				if err != nil {
+					return fmt.Errorf("size of %x: %w", key, err)
+				}
+				desc.TotalBytes += n
+			}
+			desc.NumBlobs = int64(len(keys))
+		}
+
+		if describeFlags.JSON {
+			fmt.Println(config.ToJSON(desc))
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 4, 8, 1, ' ', 0)
+		fmt.Fprintf(tw, "name:\t%s\n", desc.Name)
+		fmt.Fprintf(tw, "description:\t%s\n", desc.Description)
+		fmt.Fprintf(tw, "file key:\t%x\n", desc.FileKey)
+		if desc.Index == nil {
+			fmt.Fprint(tw, "index key:\t(none)\n")
+		} else {
+			fmt.Fprintf(tw, "index key:\t%x\n", desc.IndexKey)
+			fmt.Fprintf(tw, "index:\t%d keys, %d bits, %d hashes, est. FPR %.4f\n",
+				desc.Index.NumKeys, desc.Index.FilterBits, desc.Index.NumHashes, desc.Index.FPR)
+		}
+		fmt.Fprintf(tw, "children:\t%d\n", desc.NumChildren)
+		if describeFlags.Size {
+			fmt.Fprintf(tw, "reachable:\t%d blobs, %d bytes\n", desc.NumBlobs, desc.TotalBytes)
+		}
+		return tw.Flush()
+	})
+}
+
+var listFlags struct {
+	DescMatch string
+	Sort      string
+	Indexed   bool
+	NoIndex   bool
+}
+
 func runList(env *command.Env, args []string) error {
-	if len(args) != 0 {
-		return env.Usagef("extra arguments after command")
+	for _, pat := range args {
+		if _, err := path.Match(pat, ""); err != nil {
+			return env.Usagef("invalid glob %q: %v", pat, err)
+		}
 	}
+	var descRE *regexp.Regexp
+	if listFlags.DescMatch != "" {
+		re, err := regexp.Compile(listFlags.DescMatch)
+		if err != nil {
+			return env.Usagef("invalid -desc-match: %v", err)
+		}
+		descRE = re
+	}
+	switch listFlags.Sort {
+	case "", "name", "desc", "size":
+	default:
+		return env.Usagef("invalid -sort %q (want name, desc, or size)", listFlags.Sort)
+	}
+	if listFlags.Indexed && listFlags.NoIndex {
+		return env.Usagef("at most one of -indexed and -no-index may be set")
+	}
+	needRoot := descRE != nil || listFlags.Indexed || listFlags.NoIndex ||
+		listFlags.Sort == "desc" || listFlags.Sort == "size"
+
 	cfg := env.Config.(*config.Settings)
 	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
-		return config.Roots(s).List(cfg.Context, "", func(key string) error {
-			fmt.Println(key)
+		type entry struct {
+			name, desc string
+			size       int
+		}
+		var out []entry
+		roots := config.Roots(s)
+		if err := roots.List(cfg.Context, "", func(key string) error {
+			if !matchAny(args, key) {
+				return nil
+			} else if !needRoot {
+				out = append(out, entry{name: key})
+				return nil
+			}
+			rp, err := root.Open(cfg.Context, roots, key)
+			if err != nil {
+				return err
+			} else if descRE != nil && !descRE.MatchString(rp.Description) {
+				return nil
+			} else if listFlags.Indexed && rp.IndexKey == "" {
+				return nil
+			} else if listFlags.NoIndex && rp.IndexKey != "" {
+				return nil
+			}
+			e := entry{name: key, desc: rp.Description}
+			if listFlags.Sort == "size" && rp.IndexKey != "" {
+				idx, err := config.LoadIndex(cfg.Context, s, rp.IndexKey)
+				if err != nil {
+					fmt.Fprintf(env, "Warning: root %q: %v\n", key, err)
+				} else {
+					e.size = idx.Stats().NumKeys
+				}
+			}
+			out = append(out, e)
 			return nil
-		})
+		}); err != nil {
+			return err
+		}
+
+		switch listFlags.Sort {
+		case "name":
+			sort.SliceStable(out, func(i, j int) bool { return out[i].name < out[j].name })
+		case "desc":
+			sort.SliceStable(out, func(i, j int) bool { return out[i].desc < out[j].desc })
+		case "size":
+			sort.SliceStable(out, func(i, j int) bool { return out[i].size > out[j].size })
+		}
+		for _, e := range out {
+			fmt.Println(e.name)
+		}
+		return nil
 	})
 }
 
+// matchAny reports whether name matches any of the glob patterns. An empty
+// list of patterns matches every name.
+func matchAny(pats []string, name string) bool {
+	if len(pats) == 0 {
+		return true
+	}
+	for _, pat := range pats {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
 var createFlags struct {
 	Replace bool
 	FileKey string
+	Check   bool
+}
+
+func runOverlap(env *command.Env, args []string) error {
+	if len(args) != 2 {
+		return env.Usagef("got %d arguments, wanted 2 root keys", len(args))
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		var sets [2]map[string]bool
+		for i, key := range args {
+			rp, err := root.Open(cfg.Context, config.Roots(s), key)
+			if err != nil {
+				return err
+			}
+			rf, err := rp.File(cfg.Context, s)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env, "Scanning data reachable from %q (%x)...\n", key, rp.FileKey)
+			sets[i] = make(map[string]bool)
+			if rp.IndexKey != "" {
+				sets[i][rp.IndexKey] = true
+			}
+			if err := rf.Scan(cfg.Context, func(key string, isFile bool) bool {
+				sets[i][key] = true
+				return true
+			}); err != nil {
+				return fmt.Errorf("scanning %q: %w", key, err)
+			}
+		}
+
+		// Classify each key, and tally the sizes of each class.
+		const onlyA, onlyB, shared = 0, 1, 2
+		var count, size [3]int64
+		ctx, cancel := context.WithCancel(cfg.Context)
+		defer cancel()
+		g, run := taskgroup.New(taskgroup.Trigger(cancel)).Limit(64)
+		tally := func(class int, key string) {
+			count[class]++
+			run(func() error {
+				n, err := s.Size(ctx, key)
+				if err != nil {
+					return fmt.Errorf("size of %x: %w", key, err)
+				}
+				atomic.AddInt64(&size[class], n)
+				return nil
+			})
+		}
+		for key := range sets[0] {
+			if sets[1][key] {
+				tally(shared, key)
+			} else {
+				tally(onlyA, key)
+			}
+		}
+		for key := range sets[1] {
+			if !sets[0][key] {
+				tally(onlyB, key)
+			}
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 4, 8, 1, ' ', 0)
+		fmt.Fprint(tw, "\tOBJECTS\tBYTES\n")
+		fmt.Fprintf(tw, "only %q\t%d\t%d\n", args[0], count[onlyA], size[onlyA])
+		fmt.Fprintf(tw, "only %q\t%d\t%d\n", args[1], count[onlyB], size[onlyB])
+		fmt.Fprintf(tw, "shared\t%d\t%d\n", count[shared], size[shared])
+		return tw.Flush()
+	})
+}
+
+func runVerifyIndex(env *command.Env, args []string) error {
+	if len(args) == 0 {
+		return env.Usagef("missing required <root-key>")
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		var nfail int
+		for _, key := range args {
+			key = strings.TrimPrefix(key, "@")
+			rp, err := root.Open(cfg.Context, config.Roots(s), key)
+			if err != nil {
+				return err
+			}
+			if rp.IndexKey == "" {
+				fmt.Printf("%s: no index\n", key)
+				continue
+			}
+			idx, err := config.LoadIndex(cfg.Context, s, rp.IndexKey)
+			if err != nil {
+				return err
+			}
+			rf, err := rp.File(cfg.Context, s)
+			if err != nil {
+				return err
+			}
+
+			// Every reachable key must be in the index. Keys are counted as the
+			// index does when it is built, so that the difference estimates the
+			// number of keys in the index that are no longer reachable.
+			fmt.Fprintf(env, "Scanning data reachable from %q (%x)...\n", key, rp.FileKey)
+			var nscan, nmissing int
+			missing := make(map[string]bool)
+			if err := rf.Scan(cfg.Context, func(bkey string, isFile bool) bool {
+				nscan++
+				if !idx.Has(bkey) && !missing[bkey] {
+					missing[bkey] = true
+					nmissing++
+					fmt.Printf("%s: missing %x\n", key, bkey)
+				}
+				return true
+			}); err != nil {
+				return fmt.Errorf("scanning %q: %w", key, err)
+			}
+			extra := idx.Len() - nscan
+			if extra < 0 {
+				extra = 0
+			}
+			verdict := "PASS"
+			if nmissing != 0 {
+				verdict = "FAIL"
+				nfail++
+			}
+			fmt.Printf("%s: %s (%d reachable, %d missing from index, ~%d extra, est. FPR %.4f)\n",
+				key, verdict, nscan, nmissing, extra, config.IndexFPR(idx.Stats()))
+		}
+		if nfail != 0 {
+			return fmt.Errorf("%d of %d indexes failed verification", nfail, len(args))
+		}
+		return nil
+	})
 }
 
 func runCreate(env *command.Env, args []string) error {
@@ -170,6 +658,15 @@ func runCreate(env *command.Env, args []string) error {
 		if err != nil {
 			return err
 		}
+		if createFlags.Check {
+			n, missing, err := checkTree(cfg.Context, s, fk)
+			if err != nil {
+				return fmt.Errorf("checking file %x: %w", fk, err)
+			} else if missing > 0 {
+				return fmt.Errorf("file %x is incomplete: %d of %d blobs missing", fk, missing, n)
+			}
+			fmt.Fprintf(env, "Checked %d blobs reachable from %x\n", n, fk)
+		}
 		return root.New(config.Roots(s), &root.Options{
 			Description: desc,
 			FileKey:     fk,
@@ -177,24 +674,114 @@ func runCreate(env *command.Env, args []string) error {
 	})
 }
 
+// checkTree verifies that all the blobs reachable from the file with the given
+// key are present in s. It returns the number of blobs checked and the number
+// that are missing. A file node that cannot be loaded is reported as an error.
+func checkTree(ctx context.Context, s blob.CAS, fileKey string) (n, missing int64, _ error) {
+	fp, err := file.Open(ctx, s, fileKey)
+	if err != nil {
+		return 0, 0, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g, run := taskgroup.New(taskgroup.Trigger(cancel)).Limit(64)
+
+	// Scanning loads every file node, so only data blocks need to be probed.
+	serr := fp.Scan(ctx, func(key string, isFile bool) bool {
+		n++
+		if !isFile {
+			run(func() error {
+				if _, err := s.Size(ctx, key); blob.IsKeyNotFound(err) {
+					atomic.AddInt64(&missing, 1)
+				} else if err != nil {
+					return err
+				}
+				return nil
+			})
+		}
+		return true
+	})
+	if err := g.Wait(); err != nil {
+		return n, missing, err
+	}
+	return n, missing, serr
+}
+
 var copyFlags struct {
 	Replace bool
+	Prefix  bool
 }
 
 func runCopy(env *command.Env, args []string) error {
-	na, err := getNameArgs(env, args)
-	if err != nil {
-		return err
-	} else if na.Args[0] == na.Key {
-		return fmt.Errorf("target %q has the same name as the source", na.Args[0])
+	if env.Command.Name == "rename" && copyFlags.Prefix {
+		return runRenamePrefix(env, args)
 	}
-	defer na.Close()
-	if err := na.Root.Save(na.Context, na.Args[0], copyFlags.Replace); err != nil {
-		return err
-	} else if env.Command.Name == "rename" {
-		return config.Roots(na.Store).Delete(na.Context, na.Key)
+	return withNameArgs(env, args, func(na *rootArgs) error {
+		if na.Args[0] == na.Key {
+			return fmt.Errorf("target %q has the same name as the source", na.Args[0])
+		} else if err := na.Root.Save(na.Context, na.Args[0], copyFlags.Replace); err != nil {
+			return err
+		} else if env.Command.Name == "rename" {
+			return config.Roots(na.Store).Delete(na.Context, na.Key)
+		}
+		return nil
+	})
+}
+
+func runRenamePrefix(env *command.Env, args []string) error {
+	if len(args) != 2 {
+		return env.Usagef("got %d arguments, wanted <old-prefix> <new-prefix>", len(args))
+	} else if args[0] == args[1] {
+		return env.Usagef("the old and new prefixes are the same")
 	}
-	return nil
+	oldPrefix, newPrefix := args[0], args[1]
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		roots := config.Roots(s)
+
+		// Plan all the renamings before changing anything.
+		var plan [][2]string
+		sources := make(map[string]bool)
+		if err := roots.List(cfg.Context, "", func(key string) error {
+			if strings.HasPrefix(key, oldPrefix) {
+				plan = append(plan, [2]string{key, newPrefix + strings.TrimPrefix(key, oldPrefix)})
+				sources[key] = true
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(plan) == 0 {
+			return fmt.Errorf("no roots have prefix %q", oldPrefix)
+		}
+		for _, p := range plan {
+			if sources[p[1]] {
+				return fmt.Errorf("target %q is also a source name", p[1])
+			} else if copyFlags.Replace {
+				continue
+			}
+			if _, err := root.Open(cfg.Context, roots, p[1]); err == nil {
+				return fmt.Errorf("target %q already exists", p[1])
+			} else if !blob.IsKeyNotFound(err) {
+				return err
+			}
+		}
+
+		for _, p := range plan {
+			rp, err := root.Open(cfg.Context, roots, p[0])
+			if err != nil {
+				return err
+			}
+			if err := rp.Save(cfg.Context, p[1], copyFlags.Replace); err != nil {
+				return err
+			} else if err := roots.Delete(cfg.Context, p[0]); err != nil {
+				return err
+			}
+			fmt.Printf("%s -> %s\n", p[0], p[1])
+		}
+		return nil
+	})
 }
 
 func runDelete(env *command.Env, args []string) error {
@@ -216,33 +803,36 @@ func runDelete(env *command.Env, args []string) error {
 }
 
 func runEditDesc(env *command.Env, args []string) error {
-	na, err := getNameArgs(env, args)
-	if err != nil {
-		return err
-	}
-	defer na.Close()
-	na.Root.Description = strings.Join(na.Args, " ")
-	return na.Root.Save(na.Context, na.Key, true)
+	return withNameArgs(env, args, func(na *rootArgs) error {
+		na.Root.Description = strings.Join(na.Args, " ")
+		return na.Root.Save(na.Context, na.Key, true)
+	})
 }
 
-func runEditFile(env *command.Env, args []string) error {
-	na, err := getNameArgs(env, args)
-	if err != nil {
-		return err
-	}
-	defer na.Close()
+var editFileFlags struct {
+	KeepHistory int
+}
 
-	key, err := config.ParseKey(na.Args[0])
-	if err != nil {
-		return err
-	} else if _, err := file.Open(na.Context, na.Store, key); err != nil {
-		return err
-	}
-	if key != na.Root.FileKey {
-		na.Root.IndexKey = "" // invalidate the index
-	}
-	na.Root.FileKey = key
-	return na.Root.Save(na.Context, na.Key, true)
+func runEditFile(env *command.Env, args []string) error {
+	return withNameArgs(env, args, func(na *rootArgs) error {
+		key, err := config.ParseKey(na.Args[0])
+		if err != nil {
+			return err
+		} else if _, err := file.Open(na.Context, na.Store, key); err != nil {
+			return err
+		}
+		if key != na.Root.FileKey {
+			if editFileFlags.KeepHistory > 0 {
+				old := *na.Root
+				if err := pushHistory(na.Context, config.Roots(na.Store), na.Key, &old, editFileFlags.KeepHistory); err != nil {
+					return fmt.Errorf("saving history: %w", err)
+				}
+			}
+			na.Root.IndexKey = "" // invalidate the index
+		}
+		na.Root.FileKey = key
+		return na.Root.Save(na.Context, na.Key, true)
+	})
 }
 
 type rootArgs struct {
@@ -251,30 +841,154 @@ type rootArgs struct {
 	Args    []string
 	Root    *root.Root
 	Store   blob.CAS
-	Close   func()
 }
 
-func getNameArgs(env *command.Env, args []string) (*rootArgs, error) {
+// withNameArgs calls f with the root named by the first of args, and the rest
+// of args, using a store opened from the configuration.
+func withNameArgs(env *command.Env, args []string, f func(*rootArgs) error) error {
 	if len(args) < 2 {
-		return nil, env.Usagef("incorrect arguments")
+		return env.Usagef("incorrect arguments")
 	}
 	key := args[0]
 	cfg := env.Config.(*config.Settings)
-	bs, err := cfg.OpenStore()
+	return cfg.WithStore(cfg.Context, func(bs blob.CAS) error {
+		rp, err := root.Open(cfg.Context, config.Roots(bs), key)
+		if err != nil {
+			return err
+		}
+		return f(&rootArgs{
+			Context: cfg.Context,
+			Key:     key,
+			Args:    args[1:],
+			Root:    rp,
+			Store:   bs,
+		})
+	})
+}
+
+var mergeFlags struct {
+	Prefer  string
+	Types   string
+	Replace bool
+}
+
+func runMerge(env *command.Env, args []string) error {
+	if len(args) != 3 {
+		return env.Usagef("got %d arguments, wanted 2 root keys and a new name", len(args))
+	}
+	m := &merger{preferA: mergeFlags.Prefer == "a"}
+	if p := mergeFlags.Prefer; p != "a" && p != "b" {
+		return env.Usagef("invalid -prefer value %q", p)
+	}
+	switch mergeFlags.Types {
+	case "prefer", "dir", "error":
+		m.types = mergeFlags.Types
+	default:
+		return env.Usagef("invalid -types value %q", mergeFlags.Types)
+	}
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		var files [2]*file.File
+		for i, key := range args[:2] {
+			rp, err := root.Open(cfg.Context, config.Roots(s), key)
+			if err != nil {
+				return err
+			}
+			files[i], err = rp.File(cfg.Context, s)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The merge updates the in-memory copy of the first tree. The stored
+		// trees are not affected, since flushing writes new nodes.
+		if !files[0].Stat().Mode.IsDir() || !files[1].Stat().Mode.IsDir() {
+			return errors.New("both roots must refer to directories")
+		} else if err := m.merge(cfg.Context, "", files[0], files[1]); err != nil {
+			return err
+		}
+		fk, err := files[0].Flush(cfg.Context)
+		if err != nil {
+			return err
+		}
+		if err := root.New(config.Roots(s), &root.Options{
+			Description: fmt.Sprintf("Merge of %q and %q", args[0], args[1]),
+			FileKey:     fk,
+		}).Save(cfg.Context, args[2], mergeFlags.Replace); err != nil {
+			return err
+		}
+		fmt.Fprintf(env, "Merged %q and %q: %d file collisions, %d directory/file collisions\n",
+			args[0], args[1], m.collisions, m.mixed)
+		fmt.Printf("%x\n", fk)
+		return nil
+	})
+}
+
+// A merger merges the contents of one directory tree into another.
+type merger struct {
+	preferA    bool   // on collision, keep the version from the first tree
+	types      string // policy for directory-vs-file collisions
+	collisions int    // non-directory paths present in both with different contents
+	mixed      int    // paths that are a directory in one tree only
+}
+
+// merge adds the children of b to a, recursively merging directories present
+// in both. The path is the location of a relative to the root, for errors.
+func (m *merger) merge(ctx context.Context, path string, a, b *file.File) error {
+	for _, name := range b.Child().Names() {
+		bkid, err := b.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !a.Child().Has(name) {
+			a.Child().Set(name, bkid)
+			continue
+		}
+		akid, err := a.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		kpath := path + "/" + name
+		aDir, bDir := akid.Stat().Mode.IsDir(), bkid.Stat().Mode.IsDir()
+		if aDir && bDir {
+			if err := m.merge(ctx, kpath, akid, bkid); err != nil {
+				return err
+			}
+			continue
+		} else if aDir != bDir {
+			m.mixed++
+			if m.types == "error" {
+				return fmt.Errorf("path %q is a directory in only one tree", kpath)
+			} else if m.types == "dir" {
+				if bDir {
+					a.Child().Set(name, bkid)
+				}
+				continue
+			}
+		} else if same, err := sameFile(ctx, akid, bkid); err != nil {
+			return err
+		} else if same {
+			continue
+		} else {
+			m.collisions++
+		}
+		if !m.preferA {
+			a.Child().Set(name, bkid)
+		}
+	}
+	return nil
+}
+
+// sameFile reports whether a and b have the same storage key.
+func sameFile(ctx context.Context, a, b *file.File) (bool, error) {
+	ak, err := a.Flush(ctx)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	rp, err := root.Open(cfg.Context, config.Roots(bs), key)
+	bk, err := b.Flush(ctx)
 	if err != nil {
-		blob.CloseStore(cfg.Context, bs)
-		return nil, err
+		return false, err
 	}
-	return &rootArgs{
-		Context: cfg.Context,
-		Key:     key,
-		Args:    args[1:],
-		Root:    rp,
-		Store:   bs,
-		Close:   func() { blob.CloseStore(cfg.Context, bs) },
-	}, nil
+	return ak == bk, nil
 }
@@ -0,0 +1,195 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdroot
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file"
+	"github.com/creachadair/ffs/file/root"
+	"github.com/creachadair/ffstools/ffs/config"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// rootBackup is the encoding of a set of exported root pointers.
+type rootBackup struct {
+	Roots []*rootRecord `json:"roots" yaml:"roots"`
+}
+
+// rootRecord is the encoding of a single exported root pointer. Storage keys
+// are encoded as base64.
+type rootRecord struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	FileKey     string `json:"fileKey" yaml:"fileKey"`
+	IndexKey    string `json:"indexKey,omitempty" yaml:"indexKey,omitempty"`
+	OwnerKey    string `json:"ownerKey,omitempty" yaml:"ownerKey,omitempty"`
+}
+
+var exportFlags struct {
+	Output string
+	YAML   bool
+}
+
+func runExport(env *command.Env, args []string) error {
+	for _, pat := range args {
+		if _, err := path.Match(pat, ""); err != nil {
+			return env.Usagef("invalid glob %q: %v", pat, err)
+		}
+	}
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		roots := config.Roots(s)
+		var out rootBackup
+		if err := roots.List(cfg.Context, "", func(key string) error {
+			if !matchAny(args, key) {
+				return nil
+			}
+			rp, err := root.Open(cfg.Context, roots, key)
+			if err != nil {
+				return fmt.Errorf("open root %q: %w", key, err)
+			}
+			out.Roots = append(out.Roots, &rootRecord{
+				Name:        key,
+				Description: rp.Description,
+				FileKey:     encodeKey(rp.FileKey),
+				IndexKey:    encodeKey(rp.IndexKey),
+				OwnerKey:    encodeKey(rp.OwnerKey),
+			})
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		var bits []byte
+		var err error
+		if exportFlags.YAML {
+			bits, err = yaml.Marshal(out)
+		} else {
+			bits, err = json.MarshalIndent(out, "", "  ")
+			bits = append(bits, '\n')
+		}
+		if err != nil {
+			return err
+		}
+		if err := config.WithOutput(exportFlags.Output, func(w io.Writer) error {
+			_, err := w.Write(bits)
+			return err
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(env, "Exported %d roots\n", len(out.Roots))
+		return nil
+	})
+}
+
+var importFlags struct {
+	Replace bool
+	Force   bool
+}
+
+func runImport(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return env.Usagef("got %d arguments, wanted a file name", len(args))
+	}
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	// N.B. YAML is a superset of JSON, so this handles both formats.
+	var in rootBackup
+	if err := yaml.Unmarshal(data, &in); err != nil {
+		return fmt.Errorf("invalid root backup: %w", err)
+	}
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		roots := config.Roots(s)
+		var nsaved, nskipped int
+		for _, rec := range in.Roots {
+			if rec.Name == "" {
+				return fmt.Errorf("root with file key %q has no name", rec.FileKey)
+			}
+			opts, err := rec.options()
+			if err != nil {
+				return fmt.Errorf("root %q: %w", rec.Name, err)
+			}
+			if _, err := file.Open(cfg.Context, s, opts.FileKey); err != nil {
+				if !importFlags.Force {
+					fmt.Fprintf(env, "Skipping root %q: %v\n", rec.Name, err)
+					nskipped++
+					continue
+				}
+				fmt.Fprintf(env, "Warning: root %q: %v\n", rec.Name, err)
+			}
+			if err := root.New(roots, opts).Save(cfg.Context, rec.Name, importFlags.Replace); err != nil {
+				return fmt.Errorf("save root %q: %w", rec.Name, err)
+			}
+			fmt.Println(rec.Name)
+			nsaved++
+		}
+		fmt.Fprintf(env, "Imported %d roots, skipped %d\n", nsaved, nskipped)
+		return nil
+	})
+}
+
+// options decodes the keys of r into root options. The keys are encoded as
+// by encodeKey.
+func (r *rootRecord) options() (*root.Options, error) {
+	if r.FileKey == "" {
+		return nil, errors.New("missing file key")
+	}
+	opts := &root.Options{Description: r.Description}
+	for _, k := range []struct {
+		enc string
+		dec *string
+	}{
+		{r.FileKey, &opts.FileKey},
+		{r.IndexKey, &opts.IndexKey},
+		{r.OwnerKey, &opts.OwnerKey},
+	} {
+		if k.enc == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(k.enc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", k.enc, err)
+		}
+		*k.dec = string(key)
+	}
+	return opts, nil
+}
+
+// encodeKey encodes a storage key as base64, or "" if key is empty.
+func encodeKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
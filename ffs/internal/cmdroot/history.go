@@ -0,0 +1,103 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdroot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/file/root"
+	"github.com/creachadair/ffstools/ffs/config"
+)
+
+// The root wire format has no place to record history, so the prior versions
+// of a root are kept as ordinary roots named <name>~1, <name>~2, and so on,
+// from newest to oldest. Because they are roots, the garbage collector keeps
+// the data they refer to.
+
+// historyName returns the name of the nth prior version of the named root.
+func historyName(name string, n int) string { return fmt.Sprintf("%s~%d", name, n) }
+
+// pushHistory records old as the most recent prior version of the named root,
+// keeping at most keep prior versions.
+func pushHistory(ctx context.Context, roots blob.CAS, name string, old *root.Root, keep int) error {
+	// Discard versions beyond the limit, including any left by an earlier,
+	// larger limit.
+	for i := keep; ; i++ {
+		err := roots.Delete(ctx, historyName(name, i))
+		if blob.IsKeyNotFound(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Shift the remaining versions down by one, oldest first.
+	for i := keep - 1; i >= 1; i-- {
+		rp, err := root.Open(ctx, roots, historyName(name, i))
+		if blob.IsKeyNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := rp.Save(ctx, historyName(name, i+1), true); err != nil {
+			return err
+		}
+	}
+	return old.Save(ctx, historyName(name, 1), true)
+}
+
+func runHistory(env *command.Env, args []string) error {
+	if len(args) != 1 {
+		return env.Usagef("got %d arguments, wanted <root-key>", len(args))
+	}
+	name := strings.TrimPrefix(args[0], "@")
+
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		roots := config.Roots(s)
+		if _, err := root.Open(cfg.Context, roots, name); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 4, 8, 1, ' ', 0)
+		fmt.Fprint(tw, "NAME\tFILE KEY\tMODIFIED\n")
+		for i := 1; ; i++ {
+			hname := historyName(name, i)
+			rp, err := root.Open(cfg.Context, roots, hname)
+			if blob.IsKeyNotFound(err) {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			// The root does not record when it was replaced; report the
+			// modification time of its file as an approximation.
+			mtime := "-"
+			if rf, err := rp.File(cfg.Context, s); err == nil {
+				if t := rf.Stat().ModTime; !t.IsZero() {
+					mtime = t.Format(time.RFC3339)
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%x\t%s\n", hname, rp.FileKey, mtime)
+		}
+		return tw.Flush()
+	})
+}
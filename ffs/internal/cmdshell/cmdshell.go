@@ -0,0 +1,333 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmdshell
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/ffstools/ffs/internal/cmdput"
+	"golang.org/x/term"
+)
+
+var Command = &command.C{
+	Name:  "shell",
+	Usage: "[<origin>[/path]]",
+	Help: `Run an interactive shell over the store.
+
+The shell reads commands from stdin, one per line, and runs them using a
+single connection to the store. If an origin path is given, the shell
+starts in that directory. Arguments may be quoted with '...' or "...",
+and lines beginning with # are ignored.
+
+The shell understands these commands:
+
+  cd [<path>]            : change the current directory
+  pwd                    : print the current directory
+  ls [flags] [<path>...] : list directories (as "file list")
+  cat [flags] <path>...  : print file contents (as "file read")
+  stat <path>...         : print file objects (as "file show")
+  get <path> <local>     : copy a file to the local filesystem
+  put <local> [<path>]   : store a local file or directory at path
+  rm [flags] <path>...   : remove paths (as "file remove")
+  help                   : print this help
+  exit, quit             : leave the shell
+
+Any other command is run as an ffs subcommand, for example "root list".
+
+When there is a current directory, paths given to the shell commands are
+relative to it; a path beginning with "/" is relative to the top of its
+origin, and a path beginning with "@" names a root. Use "cd" with no
+argument to leave the current origin, after which paths must be given in
+full as for the file commands. Since modifying a tree changes its storage
+key, it is best to work within a root.`,
+
+	Run: runShell,
+}
+
+func runShell(env *command.Env, args []string) error {
+	if len(args) > 1 {
+		return env.Usagef("got %d arguments, wanted at most one origin", len(args))
+	}
+	cfg := env.Config.(*config.Settings)
+	return cfg.WithStore(cfg.Context, func(s blob.CAS) error {
+		cfg.Shared = s
+		defer func() { cfg.Shared = nil }()
+
+		sh := &shell{top: env.Parent, self: env.Command, cfg: cfg}
+		if len(args) == 1 {
+			if err := sh.cd(args[0]); err != nil {
+				return err
+			}
+		}
+		return sh.run(os.Stdin, term.IsTerminal(int(os.Stdin.Fd())))
+	})
+}
+
+// A shell holds the state of an interactive shell session.
+type shell struct {
+	top  *command.Env // the environment of the top-level ffs command
+	self *command.C   // the shell command itself
+	cfg  *config.Settings
+	cwd  string // current directory, or "" for none
+}
+
+// run reads and executes commands from r until EOF or exit. If prompt is
+// true, a prompt is printed before each line is read.
+func (sh *shell) run(r io.Reader, prompt bool) error {
+	sc := bufio.NewScanner(r)
+	for {
+		if prompt {
+			fmt.Printf("ffs:%s> ", sh.cwd)
+		}
+		if !sc.Scan() {
+			break
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := splitWords(line)
+		if err != nil {
+			fmt.Fprintf(sh.top, "Error: %v\n", err)
+			continue
+		}
+		if words[0] == "exit" || words[0] == "quit" {
+			return nil
+		}
+		if err := sh.exec(words); err != nil && !errors.Is(err, command.ErrUsage) {
+			fmt.Fprintf(sh.top, "Error: %v\n", err)
+		}
+	}
+	if prompt {
+		fmt.Println()
+	}
+	return sc.Err()
+}
+
+// exec executes a single command given as a list of words.
+func (sh *shell) exec(words []string) error {
+	cmd, args := words[0], words[1:]
+	switch cmd {
+	case "cd":
+		if len(args) > 1 {
+			return errors.New("usage is: cd [<path>]")
+		} else if len(args) == 0 {
+			sh.cwd = ""
+			return nil
+		}
+		return sh.cd(sh.resolve(args[0]))
+
+	case "pwd":
+		fmt.Println(sh.cwd)
+		return nil
+
+	case "ls":
+		return sh.dispatchPaths(args, true, "file", "list")
+
+	case "cat":
+		return sh.dispatchPaths(args, false, "file", "read")
+
+	case "stat":
+		return sh.dispatchPaths(args, false, "file", "show")
+
+	case "rm":
+		return sh.dispatchPaths(args, false, "file", "remove")
+
+	case "get":
+		if len(args) != 2 {
+			return errors.New("usage is: get <path> <local>")
+		}
+		return sh.dispatch("file", "read", "-o", args[1], sh.resolve(args[0]))
+
+	case "put":
+		if len(args) == 0 || len(args) > 2 {
+			return errors.New("usage is: put <local> [<path>]")
+		}
+		key, err := cmdput.Put(sh.cfg.Context, sh.cfg.Shared, args[0])
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 && sh.cwd == "" {
+			fmt.Printf("%x\n", key)
+			return nil
+		}
+		target := filepath.Base(args[0])
+		if len(args) == 2 {
+			target = args[1]
+		}
+		return sh.dispatch("file", "set", sh.resolve(target), fmt.Sprintf("%x", key))
+
+	case "help":
+		if len(args) == 0 {
+			fmt.Println(sh.self.Help)
+			return nil
+		}
+		return sh.dispatch(append([]string{"help"}, args...)...)
+
+	case sh.self.Name:
+		return errors.New("already in a shell")
+	}
+	return sh.dispatch(words...)
+}
+
+// dispatchPaths runs the ffs subcommand given by names, with args. The
+// non-flag arguments are resolved as paths relative to the current directory.
+// If there are none and orCwd is true, the current directory is used.
+func (sh *shell) dispatchPaths(args []string, orCwd bool, names ...string) error {
+	cmd := sh.find(names)
+	if cmd == nil {
+		return fmt.Errorf("command %q not found", strings.Join(names, " "))
+	}
+
+	// Parse the flags so that flag values are not mistaken for paths.
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if cmd.SetFlags != nil {
+		cmd.SetFlags(sh.top, fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	rest := fs.Args()
+	out := append(names, args[:len(args)-len(rest)]...)
+	for _, arg := range rest {
+		out = append(out, sh.resolve(arg))
+	}
+	if len(rest) == 0 && orCwd && sh.cwd != "" {
+		out = append(out, sh.cwd)
+	}
+	return sh.dispatch(out...)
+}
+
+// dispatch runs the ffs subcommand named by the first word of args.
+func (sh *shell) dispatch(args ...string) error {
+	cmd := sh.top.Command.FindSubcommand(args[0])
+	if cmd == nil {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	// Commands define their flags each time they run, so the flag sets from
+	// previous runs must be discarded.
+	resetFlags(cmd)
+	return command.Run(&command.Env{
+		Parent:  sh.top,
+		Command: cmd,
+		Config:  sh.cfg,
+		Log:     sh.top.Log,
+	}, args[1:])
+}
+
+// find returns the subcommand named by the given path of names, or nil.
+func (sh *shell) find(names []string) *command.C {
+	cmd := sh.top.Command
+	for _, name := range names {
+		if cmd = cmd.FindSubcommand(name); cmd == nil {
+			return nil
+		}
+	}
+	return cmd
+}
+
+// cd changes the current directory to p, which must be a directory.
+func (sh *shell) cd(p string) error {
+	of, err := config.OpenPath(sh.cfg.Context, sh.cfg.Shared, p)
+	if err != nil {
+		return err
+	} else if !of.File.Stat().Mode.IsDir() {
+		return fmt.Errorf("%q is not a directory", p)
+	}
+	sh.cwd = p
+	return nil
+}
+
+// resolve returns the full path for arg relative to the current directory.
+func (sh *shell) resolve(arg string) string {
+	if sh.cwd == "" || strings.HasPrefix(arg, "@") {
+		return arg
+	}
+	base, rest := config.SplitPath(sh.cwd)
+	if !strings.HasPrefix(arg, "/") {
+		arg = rest + "/" + arg
+	}
+	if p := path.Clean("/" + arg); p != "/" {
+		return base + p
+	}
+	return base
+}
+
+// resetFlags discards the flag sets of c and its subcommands.
+func resetFlags(c *command.C) {
+	c.Flags = flag.FlagSet{}
+	for _, sub := range c.Commands {
+		resetFlags(sub)
+	}
+}
+
+// splitWords splits line into words separated by whitespace. A word may be
+// quoted with single or double quotes, and a backslash outside single quotes
+// escapes the following character.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	var inWord bool
+	var quote rune
+	esc := false
+	for _, c := range line {
+		switch {
+		case esc:
+			cur.WriteRune(c)
+			esc = false
+		case c == '\\' && quote != '\'':
+			esc, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quotation")
+	} else if esc {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
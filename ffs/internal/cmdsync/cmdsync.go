@@ -20,6 +20,8 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -27,13 +29,37 @@ import (
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/file"
 	"github.com/creachadair/ffs/file/root"
+	"github.com/creachadair/ffs/file/wiretype"
+	"github.com/creachadair/ffs/index"
 	"github.com/creachadair/ffstools/ffs/config"
+	"github.com/creachadair/ffstools/internal/progress"
 	"github.com/creachadair/taskgroup"
 )
 
 var syncFlags struct {
-	Target  string
-	Verbose bool
+	Targets     targetList
+	Verbose     bool
+	MirrorIndex bool
+	DryRun      bool
+	CountBytes  bool
+	Verify      bool
+	Rate        string
+	Prune       bool
+	PruneRoots  bool
+	Yes         bool
+}
+
+// targetList is a repeatable flag collecting target store addresses.
+type targetList []string
+
+func (t *targetList) String() string { return strings.Join(*t, ",") }
+
+func (t *targetList) Set(s string) error {
+	if s == "" {
+		return errors.New("empty target address")
+	}
+	*t = append(*t, s)
+	return nil
 }
 
 func debug(msg string, args ...interface{}) {
@@ -50,124 +76,517 @@ var Command = &command.C{
 
 Transfer all the blobs reachable from the specified file or root
 paths into the given target store.
+
+The -to flag may be repeated to copy into several target stores in one
+pass. Each blob is read from the source once and written to every target
+that lacks it. A failure writing to one target stops copying to that
+target, but not to the others; the result for each target is reported.
+
+With -mirror-index, after copying is complete each synchronized root is
+checked in the target store: its tree is scanned there, and its index is
+checked against the keys found. If the root has no index, or if the
+index does not cover the tree, a new index is computed from the target.
+
+With -rate, the total rate at which blobs are written to the targets is
+limited to the given size per second, written as for the -max-size flag
+of put; for example, -rate 512K.
+
+With -verify, after copying is complete each target is checked for all
+the blobs it should have received. Any that are missing are copied again,
+once. In addition, a random sample of the file and data blobs copied is
+read back from each target, and their contents are checked against their
+content addresses. The discrepancies for each target are reported.
+
+With -prune, after copying is complete any blobs in each target that are
+not reachable from the specified starting points are deleted from it, so
+that the target mirrors the source exactly. Root pointers in the target
+are kept unless -prune-roots is also set, in which case roots other than
+those synchronized are deleted too. Note that pruning may remove data
+needed by roots that are kept. Because it is destructive, -prune requires
+-yes unless -dry-run is set.
+
+With -dry-run, the source is scanned and each target is checked for the
+blobs it lacks, but nothing is copied. Instead, the number of blobs that
+would be copied to each target is reported, broken down by kind (roots,
+files, data, and indexes). Add -count-bytes to also report their total
+size, which requires fetching the size of each blob from the source.
+With -prune, the number of blobs that would be pruned is also reported.
+
+Send SIGUSR1 to print the progress of the current phase to stderr.
 `,
 
 	SetFlags: func(_ *command.Env, fs *flag.FlagSet) {
-		fs.StringVar(&syncFlags.Target, "to", "", "Target store (required)")
+		fs.Var(&syncFlags.Targets, "to", "Target store (required; may be repeated)")
 		fs.BoolVar(&syncFlags.Verbose, "v", false, "Enable verbose logging")
+		fs.BoolVar(&syncFlags.MirrorIndex, "mirror-index", false, "Verify or rebuild root indexes in the target")
+		fs.StringVar(&syncFlags.Rate, "rate", "", "Maximum bytes per second to write (0 or empty for no limit)")
+		fs.BoolVar(&syncFlags.Verify, "verify", false, "Check the target for the copied blobs after copying")
+		fs.BoolVar(&syncFlags.Prune, "prune", false, "Delete blobs in the target not reachable from the source")
+		fs.BoolVar(&syncFlags.PruneRoots, "prune-roots", false, "With -prune, also delete roots not synchronized")
+		fs.BoolVar(&syncFlags.Yes, "yes", false, "Confirm that -prune may delete blobs")
+		fs.BoolVar(&syncFlags.DryRun, "dry-run", false, "Report what would be copied without copying")
+		fs.BoolVar(&syncFlags.CountBytes, "count-bytes", false, "With -dry-run, report the number of bytes to copy")
 	},
 	Run: runSync,
 }
 
+// syncTarget records the state of copying to a single target store.
+type syncTarget struct {
+	addr  string
+	store blob.CAS
+	need  scanSet // blobs to be copied to this target
+	nb    int64   // number of blobs copied
+
+	mu  sync.Mutex
+	err error // the first error writing to this target
+}
+
+func (t *syncTarget) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *syncTarget) failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err != nil
+}
+
 func runSync(env *command.Env, args []string) error {
 	if len(args) == 0 {
 		return env.Usagef("missing source keys")
-	} else if syncFlags.Target == "" {
+	} else if len(syncFlags.Targets) == 0 {
 		return env.Usagef("missing -to target store")
+	} else if syncFlags.CountBytes && !syncFlags.DryRun {
+		return env.Usagef("-count-bytes requires -dry-run")
+	} else if syncFlags.PruneRoots && !syncFlags.Prune {
+		return env.Usagef("-prune-roots requires -prune")
+	} else if syncFlags.Prune && !syncFlags.Yes && !syncFlags.DryRun {
+		return env.Usagef("-prune deletes blobs from the target; add -yes to confirm")
+	}
+	lim, err := parseRate(syncFlags.Rate)
+	if err != nil {
+		return env.Usagef("invalid -rate: %v", err)
 	}
 
 	cfg := env.Config.(*config.Settings)
 	return cfg.WithStore(cfg.Context, func(src blob.CAS) error {
-		taddr := cfg.ResolveAddress(syncFlags.Target)
-		return config.WithStore(cfg.Context, taddr, func(tgt blob.CAS) error {
+		var targets []*syncTarget
+		for _, addr := range syncFlags.Targets {
+			tgt, err := cfg.OpenStoreAddress(cfg.Context, addr)
+			if err != nil {
+				return fmt.Errorf("target %q: %w", addr, err)
+			}
+			defer blob.CloseStore(cfg.Context, tgt)
+			taddr := cfg.ResolveAddress(addr)
 			fmt.Fprintf(env, "Target store: %q\n", taddr)
+			targets = append(targets, &syncTarget{addr: taddr, store: tgt})
+		}
 
-			// Find all the blobs reachable from the specified starting points.
-			worklist := make(scanSet)
-			for _, elt := range args {
-				of, err := config.OpenPath(cfg.Context, src, elt)
-				if err != nil {
-					return err
-				}
+		prog := progress.NewCounter("sync scan", 0)
+		defer progress.Notify(env, prog)()
 
-				if of.Root != nil && of.Base == of.File {
-					fmt.Fprintf(env, "Scanning data reachable from root %q\n", of.RootKey)
-					err = worklist.root(cfg.Context, src, of.RootKey, of.Root)
-				} else {
-					fmt.Fprintf(env, "Scanning data reachable from file %x\n", of.FileKey)
-					err = worklist.file(cfg.Context, of.File)
-				}
-				if err != nil {
-					return err
+		// Find all the blobs reachable from the specified starting points.
+		worklist := make(scanSet)
+		var roots []string
+		indexKeys := make(map[string]bool)
+		pc := config.NewPathCache(src)
+		for _, elt := range args {
+			of, err := pc.OpenPath(cfg.Context, elt)
+			if err != nil {
+				return err
+			}
+
+			if of.Root != nil && of.Base == of.File {
+				fmt.Fprintf(env, "Scanning data reachable from root %q\n", of.RootKey)
+				err = worklist.root(cfg.Context, src, of.RootKey, of.Root, prog)
+				roots = append(roots, of.RootKey)
+				if of.Root.IndexKey != "" {
+					indexKeys[of.Root.IndexKey] = true
 				}
+			} else {
+				fmt.Fprintf(env, "Scanning data reachable from file %x\n", of.FileKey)
+				err = worklist.file(cfg.Context, of.File, prog)
 			}
-			fmt.Fprintf(env, "Found %d reachable objects\n", len(worklist))
-			if len(worklist) == 0 {
-				return errors.New("no matching objects")
+			if err != nil {
+				return err
 			}
+		}
+		fmt.Fprintf(env, "Found %d reachable objects\n", len(worklist))
+		if len(worklist) == 0 {
+			return errors.New("no matching objects")
+		}
 
-			// Remove from the worklist all blobs already stored in the target
-			// that are not scheduled for replacement. Blobs marked as root (R) or
-			// otherwise requiring replacement (+) are retained regardless.
-			if err := tgt.List(cfg.Context, "", func(key string) error {
-				switch worklist[key] {
+		// For each target, remove from its worklist all blobs already stored
+		// in the target that are not scheduled for replacement. Blobs marked as
+		// root (R) or otherwise requiring replacement (+) are retained
+		// regardless.
+		for _, t := range targets {
+			t.need = make(scanSet, len(worklist))
+			for key, tag := range worklist {
+				t.need[key] = tag
+			}
+			if err := t.store.List(cfg.Context, "", func(key string) error {
+				switch t.need[key] {
 				case '-', 'F':
-					delete(worklist, key)
+					delete(t.need, key)
 				}
 				return nil
 			}); err != nil {
-				return err
+				return fmt.Errorf("target %q: %w", t.addr, err)
 			}
-			fmt.Fprintf(env, "Have %d objects to copy\n", len(worklist))
+			fmt.Fprintf(env, "Have %d objects to copy to %q\n", len(t.need), t.addr)
+		}
+		if syncFlags.DryRun {
+			for _, t := range targets {
+				if err := reportDryRun(cfg.Context, env, src, t, indexKeys); err != nil {
+					return err
+				}
+				if syncFlags.Prune {
+					nd, nr, err := pruneTarget(cfg.Context, t, worklist, true)
+					if err != nil {
+						return fmt.Errorf("target %q: %w", t.addr, err)
+					}
+					fmt.Fprintf(env, "Would prune %d objects and %d roots from %q\n", nd, nr, t.addr)
+				}
+			}
+			fmt.Fprintln(env, "DRY RUN: no objects copied")
+			return nil
+		}
 
-			// Copy all remaining objects.
-			start := time.Now()
-			var nb int64
+		// Copy all remaining objects, reading each from the source once.
+		start := time.Now()
+		prog.Phase("sync copy", int64(len(worklist)))
 
-			ctx, cancel := context.WithCancel(cfg.Context)
-			defer cancel()
+		ctx, cancel := context.WithCancel(cfg.Context)
+		defer cancel()
 
-			g, run := taskgroup.New(taskgroup.Trigger(cancel)).Limit(128)
-			for key, tag := range worklist {
-				if ctx.Err() != nil {
-					break
-				}
-
-				key, tag := key, tag
-				run(func() error {
-					defer atomic.AddInt64(&nb, 1)
-					switch tag {
-					case 'R':
-						debug("- copying root %q", key)
-						return copyBlob(ctx, config.Roots(src), config.Roots(tgt), key, true)
-					case '+':
-						return copyBlob(ctx, src, tgt, key, true)
-					case 'F':
-						debug("- copying file %x", key)
-						return copyBlob(ctx, src, tgt, key, false)
-					case '-':
-						return copyBlob(ctx, src, tgt, key, false)
-					default:
-						panic("unknown tag " + string(tag))
+		g, run := taskgroup.New(taskgroup.Trigger(cancel)).Limit(128)
+		for key, tag := range worklist {
+			if ctx.Err() != nil {
+				break
+			}
+			var dsts []*syncTarget
+			for _, t := range targets {
+				if _, ok := t.need[key]; ok {
+					dsts = append(dsts, t)
+				}
+			}
+			if len(dsts) == 0 {
+				prog.Add(1)
+				continue
+			}
+
+			key, tag := key, tag
+			run(func() error {
+				defer prog.Add(1)
+				from := src
+				replace := tag == 'R' || tag == '+'
+				switch tag {
+				case 'R':
+					debug("- copying root %q", key)
+					from = config.Roots(src)
+				case 'F':
+					debug("- copying file %x", key)
+				case '+', '-':
+				default:
+					panic("unknown tag " + string(tag))
+				}
+				bits, err := from.Get(ctx, key)
+				if err != nil {
+					return err
+				}
+				for _, t := range dsts {
+					if t.failed() {
+						continue
+					}
+					to := t.store
+					if tag == 'R' {
+						to = config.Roots(t.store)
+					}
+					if err := lim.wait(ctx, len(bits)); err != nil {
+						return err
 					}
-				})
+					if err := putBlob(ctx, to, key, bits, replace); err != nil {
+						t.fail(fmt.Errorf("copying %x: %w", key, err))
+					} else {
+						atomic.AddInt64(&t.nb, 1)
+					}
+				}
+				return nil
+			})
+		}
+		cerr := g.Wait()
+		elapsed := time.Since(start).Truncate(10 * time.Millisecond)
+		var nfail int
+		for _, t := range targets {
+			if t.err != nil {
+				nfail++
+				fmt.Fprintf(env, "Copied %d blobs to %q, failed: %v [%v elapsed]\n", t.nb, t.addr, t.err, elapsed)
+			} else {
+				fmt.Fprintf(env, "Copied %d blobs to %q [%v elapsed]\n", t.nb, t.addr, elapsed)
 			}
-			cerr := g.Wait()
-			fmt.Fprintf(env, "Copied %d blobs [%v elapsed]\n",
-				nb, time.Since(start).Truncate(10*time.Millisecond))
+		}
+		if cerr != nil {
 			return cerr
-		})
+		}
+
+		// Check that the copied blobs landed in each target.
+		if syncFlags.Verify {
+			for _, t := range targets {
+				if t.err != nil {
+					continue
+				}
+				problems, err := verifyTarget(cfg.Context, src, t)
+				if err != nil {
+					t.fail(fmt.Errorf("verifying: %w", err))
+				} else if len(problems) != 0 {
+					for _, p := range problems {
+						fmt.Fprintf(env, "Target %q: %s\n", t.addr, p)
+					}
+					t.fail(fmt.Errorf("verify found %d problems", len(problems)))
+				}
+				if t.err != nil {
+					nfail++
+					fmt.Fprintf(env, "Target %q: %v\n", t.addr, t.err)
+				} else {
+					fmt.Fprintf(env, "Target %q: verify OK\n", t.addr)
+				}
+			}
+		}
+
+		// Remove unreachable blobs from each target. This must precede the
+		// index check, which may store new indexes that are not in the
+		// worklist.
+		if syncFlags.Prune {
+			for _, t := range targets {
+				if t.err != nil {
+					continue
+				}
+				nd, nr, err := pruneTarget(cfg.Context, t, worklist, false)
+				if err != nil {
+					t.fail(fmt.Errorf("pruning: %w", err))
+					nfail++
+					fmt.Fprintf(env, "Target %q: %v\n", t.addr, t.err)
+					continue
+				}
+				fmt.Fprintf(env, "Pruned %d objects and %d roots from %q\n", nd, nr, t.addr)
+			}
+		}
+
+		// Check the indexes of the synchronized roots against each target.
+		if syncFlags.MirrorIndex {
+			for _, t := range targets {
+				if t.err != nil {
+					continue
+				}
+				for _, key := range roots {
+					status, err := mirrorIndex(cfg.Context, t.store, key)
+					if err != nil {
+						t.fail(fmt.Errorf("checking index for root %q: %w", key, err))
+						nfail++
+						fmt.Fprintf(env, "Target %q: %v\n", t.addr, t.err)
+						break
+					}
+					fmt.Fprintf(env, "Root %q in %q: index %s\n", key, t.addr, status)
+				}
+			}
+		}
+		if nfail > 0 {
+			return fmt.Errorf("sync failed for %d of %d targets", nfail, len(targets))
+		}
+		return nil
 	})
 }
 
+// verifySample is the maximum number of blobs whose content is checked in
+// each target by -verify.
+const verifySample = 64
+
+// verifyTarget checks that every blob needed by t is present in its store.
+// Missing blobs are copied again from src, once. A random sample of the file
+// and data blobs is read back and checked against its content address. It
+// returns a description of each problem that remains.
+func verifyTarget(ctx context.Context, src blob.CAS, t *syncTarget) ([]string, error) {
+	have := make(map[string]bool)
+	if err := t.store.List(ctx, "", func(key string) error {
+		if _, ok := t.need[key]; ok {
+			have[key] = true
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for key, tag := range t.need {
+		from, to := src, t.store
+		if tag == 'R' {
+			from, to = config.Roots(src), config.Roots(t.store)
+			if _, err := to.Size(ctx, key); err == nil {
+				continue
+			} else if !blob.IsKeyNotFound(err) {
+				return nil, err
+			}
+		} else if have[key] {
+			continue
+		}
+
+		debug("- recopying missing blob %x", key)
+		bits, err := from.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := putBlob(ctx, to, key, bits, tag == 'R' || tag == '+'); err != nil {
+			problems = append(problems, fmt.Sprintf("missing %x (retry failed: %v)", key, err))
+		} else if _, err := to.Size(ctx, key); err != nil {
+			problems = append(problems, fmt.Sprintf("missing %x after retry: %v", key, err))
+		}
+	}
+
+	// N.B. Map iteration order is unspecified, which suffices for sampling.
+	var nsample int
+	for key, tag := range t.need {
+		if nsample >= verifySample {
+			break
+		} else if tag != 'F' && tag != '-' {
+			continue
+		}
+		nsample++
+		data, err := t.store.Get(ctx, key)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("reading %x: %v", key, err))
+			continue
+		}
+		got, err := t.store.CASKey(ctx, data)
+		if err != nil {
+			return nil, err
+		} else if got != key {
+			problems = append(problems, fmt.Sprintf("mismatch %x (content hashes to %x)", key, got))
+		}
+	}
+	return problems, nil
+}
+
+// pruneTarget deletes from t each blob that is not in the worklist, and
+// reports the number of data and root blobs deleted. Roots are considered
+// only if -prune-roots is set. If dryRun is true, the blobs are counted but
+// not deleted.
+func pruneTarget(ctx context.Context, t *syncTarget, worklist scanSet, dryRun bool) (ndata, nroot int64, _ error) {
+	prune := func(s blob.CAS, keep func(string) bool, n *int64) error {
+		var drop []string
+		if err := s.List(ctx, "", func(key string) error {
+			if !keep(key) {
+				drop = append(drop, key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if dryRun {
+			*n = int64(len(drop))
+			return nil
+		}
+		g, run := taskgroup.New(nil).Limit(64)
+		for _, key := range drop {
+			key := key
+			run(func() error {
+				debug("- pruning %x", key)
+				err := s.Delete(ctx, key)
+				if err == nil {
+					atomic.AddInt64(n, 1)
+				} else if !blob.IsKeyNotFound(err) {
+					return fmt.Errorf("deleting %x: %w", key, err)
+				}
+				return nil
+			})
+		}
+		return g.Wait()
+	}
+
+	if err := prune(t.store, func(key string) bool {
+		tag, ok := worklist[key]
+		return ok && tag != 'R'
+	}, &ndata); err != nil {
+		return ndata, nroot, err
+	}
+	if syncFlags.PruneRoots {
+		if err := prune(config.Roots(t.store), func(key string) bool {
+			return worklist[key] == 'R'
+		}, &nroot); err != nil {
+			return ndata, nroot, err
+		}
+	}
+	return ndata, nroot, nil
+}
+
+// reportDryRun prints a summary of the blobs that would be copied to t, by
+// kind. If -count-bytes is set, their total size is also reported.
+func reportDryRun(ctx context.Context, env *command.Env, src blob.CAS, t *syncTarget, indexKeys map[string]bool) error {
+	var nroot, nfile, ndata, nindex, nother int
+	var nbytes int64
+	for key, tag := range t.need {
+		from := src
+		switch {
+		case tag == 'R':
+			nroot++
+			from = config.Roots(src)
+		case tag == 'F':
+			nfile++
+		case indexKeys[key]:
+			nindex++
+		case tag == '-':
+			ndata++
+		default:
+			nother++
+		}
+		if syncFlags.CountBytes {
+			n, err := from.Size(ctx, key)
+			if err != nil {
+				return fmt.Errorf("size of %x: %w", key, err)
+			}
+			nbytes += n
+		}
+	}
+	msg := fmt.Sprintf("Would copy %d objects to %q: %d roots, %d files, %d data, %d index",
+		len(t.need), t.addr, nroot, nfile, ndata, nindex)
+	if nother != 0 {
+		msg += fmt.Sprintf(", %d other", nother)
+	}
+	if syncFlags.CountBytes {
+		msg += fmt.Sprintf(" (%d bytes)", nbytes)
+	}
+	fmt.Fprintln(env, msg)
+	return nil
+}
+
 type scanSet map[string]byte
 
-func (s scanSet) root(ctx context.Context, src blob.CAS, rootKey string, rp *root.Root) error {
+func (s scanSet) root(ctx context.Context, src blob.CAS, rootKey string, rp *root.Root, prog *progress.Counter) error {
 	s[rootKey] = 'R'
-	s[rp.OwnerKey] = '+'
-	s[rp.IndexKey] = '-'
+	if rp.OwnerKey != "" {
+		s[rp.OwnerKey] = '+'
+	}
+	if rp.IndexKey != "" {
+		s[rp.IndexKey] = '-'
+	}
 	fp, err := rp.File(ctx, src)
 	if err != nil {
 		return err
 	}
-	return s.file(ctx, fp)
+	return s.file(ctx, fp, prog)
 }
 
-func (s scanSet) file(ctx context.Context, fp *file.File) error {
+func (s scanSet) file(ctx context.Context, fp *file.File, prog *progress.Counter) error {
 	return fp.Scan(ctx, func(key string, isFile bool) bool {
 		if _, ok := s[key]; ok {
 			return false
-		} else if isFile {
+		}
+		prog.Add(1)
+		if isFile {
 			s[key] = 'F'
 		} else {
 			s[key] = '-'
@@ -176,17 +595,77 @@ func (s scanSet) file(ctx context.Context, fp *file.File) error {
 	})
 }
 
-func copyBlob(ctx context.Context, src, tgt blob.CAS, key string, replace bool) error {
-	if key == "" {
-		return nil
+// mirrorIndex checks the index of the specified root in tgt against the keys
+// reachable from its tree in tgt. If the root has no index, or its index does
+// not include every reachable key, a new index is computed and saved.  It
+// reports a description of what was done.
+func mirrorIndex(ctx context.Context, tgt blob.CAS, key string) (string, error) {
+	rp, err := root.Open(ctx, config.Roots(tgt), key)
+	if err != nil {
+		return "", err
 	}
-	bits, err := src.Get(ctx, key)
+	fp, err := rp.File(ctx, tgt)
 	if err != nil {
-		return err
+		return "", err
+	}
+	var keys []string
+	if err := fp.Scan(ctx, func(key string, isFile bool) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return "", fmt.Errorf("scanning target tree: %w", err)
+	}
+
+	status := "created"
+	if rp.IndexKey != "" {
+		idx, err := config.LoadIndex(ctx, tgt, rp.IndexKey)
+		if err != nil {
+			debug("- index for %q is unreadable: %v", key, err)
+		} else if missing := countMissing(idx, keys); missing == 0 {
+			return "ok", nil
+		} else {
+			debug("- index for %q is missing %d of %d keys", key, missing, len(keys))
+		}
+		status = "rebuilt"
+	}
+
+	n, err := tgt.Len(ctx)
+	if err != nil {
+		return "", err
+	}
+	idx := index.New(int(n), &index.Options{FalsePositiveRate: 0.01})
+	for _, key := range keys {
+		idx.Add(key)
+	}
+	rp.IndexKey, err = wiretype.Save(ctx, tgt, &wiretype.Object{
+		Value: &wiretype.Object_Index{Index: index.Encode(idx)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("saving index: %w", err)
+	}
+	if err := rp.Save(ctx, key, true); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// countMissing reports how many of keys are not present in idx.
+func countMissing(idx *index.Index, keys []string) int {
+	var missing int
+	for _, key := range keys {
+		if !idx.Has(key) {
+			missing++
+		}
 	}
-	err = tgt.Put(ctx, blob.PutOptions{
+	return missing
+}
+
+// putBlob writes data to key in tgt. If the key already exists and replace is
+// false, the existing blob is retained without error.
+func putBlob(ctx context.Context, tgt blob.CAS, key string, data []byte, replace bool) error {
+	err := tgt.Put(ctx, blob.PutOptions{
 		Key:     key,
-		Data:    bits,
+		Data:    data,
 		Replace: replace,
 	})
 	if blob.IsKeyExists(err) {
@@ -194,3 +673,50 @@ func copyBlob(ctx context.Context, src, tgt blob.CAS, key string, replace bool)
 	}
 	return err
 }
+
+// A byteLimiter paces writes so that their total size does not exceed a given
+// rate. A nil *byteLimiter does not limit.
+type byteLimiter struct {
+	rate float64 // bytes per second
+
+	mu   sync.Mutex
+	next time.Time // when the next write may begin
+}
+
+// parseRate parses a rate in bytes per second, given as a size in the format
+// accepted by config.ParseSize. An empty or zero rate returns a nil limiter.
+func parseRate(s string) (*byteLimiter, error) {
+	v, err := config.ParseSize(s)
+	if err != nil || v == 0 {
+		return nil, err
+	}
+	return &byteLimiter{rate: float64(v)}, nil
+}
+
+// wait blocks until n more bytes may be written, or until ctx ends.
+func (b *byteLimiter) wait(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	start := b.next
+	b.next = b.next.Add(time.Duration(float64(n) / b.rate * float64(time.Second)))
+	b.mu.Unlock()
+
+	d := time.Until(start)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// barInterval is the interval between redraws of a progress bar.
+const barInterval = 250 * time.Millisecond
+
+// barWidth is the number of cells in the bar of a progress bar.
+const barWidth = 30
+
+// Bar draws a progress bar for c on the current line of f, redrawing it
+// periodically until the returned function is called. If f is not a terminal,
+// Bar does nothing. The caller must call the returned function to stop drawing
+// the bar, which clears the line; nothing else should be written to f while
+// the bar is drawn.
+func Bar(f *os.File, c *Counter) (stop func()) {
+	if !term.IsTerminal(int(f.Fd())) {
+		return func() {}
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		t := time.NewTicker(barInterval)
+		defer t.Stop()
+		for {
+			fmt.Fprint(f, "\r\x1b[K", barLine(c.Snapshot()))
+			select {
+			case <-t.C:
+			case <-done:
+				fmt.Fprint(f, "\r\x1b[K")
+				return
+			}
+		}
+	}()
+	return func() { close(done); <-finished }
+}
+
+// barLine renders a progress bar line for ev.
+func barLine(ev Event) string {
+	if ev.Total <= 0 {
+		return fmt.Sprintf("%s: %d objects, %.1f/s", ev.Phase, ev.Done, ev.Rate)
+	}
+	frac := float64(ev.Done) / float64(ev.Total)
+	if frac > 1 {
+		frac = 1 // the total may be an estimate
+	}
+	n := int(frac * barWidth)
+	bar := strings.Repeat("=", n)
+	if n < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-n-1)
+	}
+	return fmt.Sprintf("%s [%s] %3d%% %d/%d, %.1f/s",
+		ev.Phase, bar, int(frac*100), ev.Done, ev.Total, ev.Rate)
+}
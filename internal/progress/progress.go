@@ -0,0 +1,213 @@
+// Copyright 2022 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress tracks the progress of long-running commands, and reports
+// their status on demand.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Counter tracks the number of objects processed by the current phase of a
+// long-running operation. A Counter is safe for concurrent use.
+type Counter struct {
+	done int64 // accessed atomically
+
+	mu    sync.Mutex
+	phase string
+	total int64 // 0 means unknown
+	start time.Time
+}
+
+// NewCounter constructs a new counter for the given phase, with the expected
+// total number of objects (0 if unknown).
+func NewCounter(phase string, total int64) *Counter {
+	return &Counter{phase: phase, total: total, start: time.Now()}
+}
+
+// Phase resets c to begin a new phase with the given expected total. If a JSON
+// progress stream is enabled, a final event for the previous phase is written
+// to it, if that phase made any progress.
+func (c *Counter) Phase(phase string, total int64) {
+	if ev := c.Snapshot(); ev.Done != 0 {
+		writeEvent(ev)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.phase, c.total, c.start = phase, total, time.Now()
+	atomic.StoreInt64(&c.done, 0)
+}
+
+// Add adds n to the number of objects processed in the current phase.
+func (c *Counter) Add(n int64) { atomic.AddInt64(&c.done, n) }
+
+// AddTotal adds n to the expected total for the current phase. This is useful
+// when the total is discovered as the phase proceeds.
+func (c *Counter) AddTotal(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += n
+}
+
+// An Event is a snapshot of the progress of a counter, as written to the JSON
+// progress stream.
+type Event struct {
+	Phase     string  `json:"phase"`
+	Done      int64   `json:"done"`
+	Total     int64   `json:"total,omitempty"` // 0 means unknown
+	Rate      float64 `json:"rate"`            // objects per second
+	ElapsedMs int64   `json:"elapsedMs"`
+}
+
+// Snapshot returns an event describing the current phase of c.
+func (c *Counter) Snapshot() Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	done := atomic.LoadInt64(&c.done)
+	elapsed := time.Since(c.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = math.Round(float64(done)/elapsed.Seconds()*10) / 10
+	}
+	return Event{
+		Phase:     c.phase,
+		Done:      done,
+		Total:     c.total,
+		Rate:      rate,
+		ElapsedMs: elapsed.Milliseconds(),
+	}
+}
+
+// Status returns a one-line human-readable summary of the current phase,
+// including the rate of progress and, if the total is known, the estimated
+// time remaining.
+func (c *Counter) Status() string {
+	ev := c.Snapshot()
+	elapsed := time.Duration(ev.ElapsedMs) * time.Millisecond
+
+	msg := fmt.Sprintf("%s: %d", ev.Phase, ev.Done)
+	if ev.Total > 0 {
+		msg += fmt.Sprintf("/%d", ev.Total)
+	}
+	msg += fmt.Sprintf(" objects, %.1f/s [%v elapsed", ev.Rate, elapsed.Truncate(time.Second))
+	if ev.Total > 0 && ev.Rate > 0 && ev.Done < ev.Total {
+		eta := time.Duration(float64(ev.Total-ev.Done) / ev.Rate * float64(time.Second))
+		msg += fmt.Sprintf(", ETA %v", eta.Truncate(time.Second))
+	}
+	return msg + "]"
+}
+
+// jsonInterval is the minimum interval between events written to the JSON
+// progress stream for a counter.
+const jsonInterval = time.Second
+
+// The JSON progress stream, if one is enabled.
+var jsonStream struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+// SetJSONStream enables a stream of progress events to w, encoded as JSON
+// objects one per line. If w == nil, the stream is disabled.
+func SetJSONStream(w io.Writer) {
+	jsonStream.Lock()
+	defer jsonStream.Unlock()
+	if w == nil {
+		jsonStream.enc = nil
+	} else {
+		jsonStream.enc = json.NewEncoder(w)
+	}
+}
+
+func writeEvent(ev Event) {
+	jsonStream.Lock()
+	defer jsonStream.Unlock()
+	if jsonStream.enc != nil {
+		jsonStream.enc.Encode(ev)
+	}
+}
+
+func hasJSONStream() bool {
+	jsonStream.Lock()
+	defer jsonStream.Unlock()
+	return jsonStream.enc != nil
+}
+
+// Notify arranges for the status of c to be written to w each time the
+// process receives a status signal (SIGUSR1 where supported). If a JSON
+// progress stream is enabled, events for c are also written to it when c
+// changes, at most once per second, and once more when notification stops.
+// The caller must call the returned function to stop notifications when c is
+// no longer in use.
+func Notify(w io.Writer, c *Counter) (stop func()) {
+	var cleanup []func()
+	var sig <-chan os.Signal
+	if len(statusSignals) != 0 {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, statusSignals...)
+		cleanup = append(cleanup, func() { signal.Stop(ch) })
+		sig = ch
+	}
+	var tick <-chan time.Time
+	if hasJSONStream() {
+		t := time.NewTicker(jsonInterval)
+		cleanup = append(cleanup, t.Stop)
+		tick = t.C
+	}
+	if sig == nil && tick == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		var last Event
+		emit := func() {
+			if ev := c.Snapshot(); ev.Phase != last.Phase || ev.Done != last.Done {
+				writeEvent(ev)
+				last = ev
+			}
+		}
+		for {
+			select {
+			case <-sig:
+				fmt.Fprintln(w, c.Status())
+			case <-tick:
+				emit()
+			case <-done:
+				if tick != nil {
+					emit()
+				}
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+		for _, f := range cleanup {
+			f()
+		}
+	}
+}